@@ -33,5 +33,9 @@ func (driver *Driver) Version() (uint64, error) {
 
 func init() {
 	driver.RegisterDriver("bash", driver.NewDriverGenerator(
-		func() driver.Driver { return &Driver{} }))
+		func() driver.Driver { return &Driver{} }).WithInfo(driver.Info{
+		FilenameExtension: "sh",
+		Description:       "Shell scripts",
+		URLFormat:         "bash://",
+	}))
 }
@@ -2,7 +2,9 @@
 package cassandra
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"net/url"
 	"strconv"
 	"strings"
@@ -15,24 +17,29 @@ import (
 )
 
 type Driver struct {
-	session *gocql.Session
+	session         *gocql.Session
+	migrationsTable string
+	lockKey         string
+	lockTimeout     time.Duration
+	leaseLock       *driver.LeaseLock
 }
 
 const (
 	tableName  = "schema_migrations"
 	versionRow = 1
+	dirtyRow   = 1
 )
 
 type counterStmt bool
 
-func (c counterStmt) String() string {
+func (c counterStmt) query(table string) string {
 	sign := ""
 	if bool(c) {
 		sign = "+"
 	} else {
 		sign = "-"
 	}
-	return "UPDATE " + tableName + " SET version = version " + sign + " 1 where versionRow = ?"
+	return "UPDATE " + table + " SET version = version " + sign + " 1 where versionRow = ?"
 }
 
 const (
@@ -46,6 +53,28 @@ const (
 // Example:
 // cassandra://localhost/SpaceOfKeys?protocol=4
 func (driver *Driver) Initialize(rawurl string, initOptions ...func(driver.Driver)) error {
+	return driver.InitializeContext(context.Background(), rawurl, initOptions...)
+}
+
+func (d *Driver) InitializeContext(ctx context.Context, rawurl string, initOptions ...func(driver.Driver)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rawurl, options, err := driver.ParseOptions(rawurl)
+	if err != nil {
+		return err
+	}
+	d.migrationsTable = tableName
+	if options.MigrationsTable != "" {
+		d.migrationsTable = options.MigrationsTable
+	}
+	d.lockKey = d.migrationsTable
+	if options.LockKey != "" {
+		d.lockKey = options.LockKey
+	}
+	d.lockTimeout = options.LockTimeout
+	d.leaseLock = driver.NewLeaseLock(leaseStore{d}, d.lockKey, options.LeaseTTL)
+
 	u, err := url.Parse(rawurl)
 
 	cluster := gocql.NewCluster(u.Host)
@@ -77,13 +106,13 @@ func (driver *Driver) Initialize(rawurl string, initOptions ...func(driver.Drive
 
 	}
 
-	driver.session, err = cluster.CreateSession()
+	d.session, err = cluster.CreateSession()
 
 	if err != nil {
 		return err
 	}
 
-	if err := driver.ensureVersionTableExists(); err != nil {
+	if err := d.ensureVersionTableExists(ctx); err != nil {
 		return err
 	}
 	return nil
@@ -94,25 +123,117 @@ func (driver *Driver) Close() error {
 	return nil
 }
 
-func (driver *Driver) ensureVersionTableExists() error {
-	err := driver.session.Query("CREATE TABLE IF NOT EXISTS " + tableName + " (version counter, versionRow bigint primary key);").Exec()
+func (driver *Driver) ensureVersionTableExists(ctx context.Context) error {
+	err := driver.session.Query("CREATE TABLE IF NOT EXISTS " + driver.migrationsTable + " (version counter, versionRow bigint primary key);").WithContext(ctx).Exec()
 	if err != nil {
 		return err
 	}
 
-	_, err = driver.Version()
+	_, err = driver.VersionContext(ctx)
 	if err != nil {
-		driver.session.Query(up.String(), versionRow).Exec()
+		driver.session.Query(up.query(driver.migrationsTable), versionRow).WithContext(ctx).Exec()
 	}
 
-	return nil
+	err = driver.session.Query("CREATE TABLE IF NOT EXISTS " + driver.dirtyTable() + " (dirtyRow int primary key, version bigint, name text, file_name text, direction text);").WithContext(ctx).Exec()
+	if err != nil {
+		return err
+	}
+
+	return driver.session.Query("CREATE TABLE IF NOT EXISTS " + driver.lockTable() + " (lockKey text primary key, owner text, hostname text, acquired_at timestamp, expires_at timestamp);").WithContext(ctx).Exec()
+}
+
+// dirtyTable is the name of the table that stores the migration file
+// that is being applied.
+func (d *Driver) dirtyTable() string {
+	return d.migrationsTable + "_dirty"
+}
+
+// lockTable is the name of the table that holds the leases of the
+// migration locks, one row per lock key.
+func (d *Driver) lockTable() string {
+	return d.migrationsTable + "_lock"
+}
+
+func (d *Driver) Lock(ctx context.Context, timeout time.Duration) error {
+	if d.lockTimeout > 0 {
+		timeout = d.lockTimeout
+	}
+	return d.leaseLock.Lock(ctx, timeout)
+}
+
+func (d *Driver) Unlock() error {
+	return d.leaseLock.Unlock()
+}
+
+func (d *Driver) Lease(ctx context.Context) (*driver.Lease, error) {
+	return d.leaseLock.Lease(ctx)
+}
+
+func (d *Driver) BreakLease(ctx context.Context) error {
+	return d.leaseLock.BreakLease(ctx)
+}
+
+func (d *Driver) LeaseLost() <-chan struct{} {
+	return d.leaseLock.Lost()
+}
+
+// leaseStore stores leases as rows of the lock table, written with
+// lightweight transactions. The rows expire with the leases by their TTL.
+type leaseStore struct {
+	d *Driver
+}
+
+// ttl returns the TTL in seconds of a lease that expires at expiresAt.
+func ttl(expiresAt, now time.Time) int {
+	seconds := int(math.Ceil(expiresAt.Sub(now).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+func (s leaseStore) Acquire(ctx context.Context, lease driver.Lease, now time.Time) (bool, error) {
+	return s.d.session.Query("INSERT INTO "+s.d.lockTable()+" (lockKey, owner, hostname, acquired_at, expires_at) VALUES (?, ?, ?, ?, ?) IF NOT EXISTS USING TTL ?",
+		lease.Key, lease.Owner, lease.Hostname, lease.AcquiredAt, lease.ExpiresAt, ttl(lease.ExpiresAt, now)).
+		WithContext(ctx).MapScanCAS(map[string]interface{}{})
+}
+
+// Renew writes all columns again, so that none of them expires
+// before the renewed lease.
+func (s leaseStore) Renew(ctx context.Context, lease driver.Lease) (bool, error) {
+	return s.d.session.Query("UPDATE "+s.d.lockTable()+" USING TTL ? SET owner = ?, hostname = ?, acquired_at = ?, expires_at = ? WHERE lockKey = ? IF owner = ?",
+		ttl(lease.ExpiresAt, time.Now()), lease.Owner, lease.Hostname, lease.AcquiredAt, lease.ExpiresAt, lease.Key, lease.Owner).
+		WithContext(ctx).MapScanCAS(map[string]interface{}{})
+}
+
+func (s leaseStore) Release(ctx context.Context, key, owner string) error {
+	_, err := s.d.session.Query("DELETE FROM "+s.d.lockTable()+" WHERE lockKey = ? IF owner = ?", key, owner).
+		WithContext(ctx).MapScanCAS(map[string]interface{}{})
+	return err
+}
+
+func (s leaseStore) Get(ctx context.Context, key string) (*driver.Lease, error) {
+	lease := driver.Lease{Key: key}
+	err := s.d.session.Query("SELECT owner, hostname, acquired_at, expires_at FROM "+s.d.lockTable()+" WHERE lockKey = ?", key).
+		WithContext(ctx).Scan(&lease.Owner, &lease.Hostname, &lease.AcquiredAt, &lease.ExpiresAt)
+	switch {
+	case err == gocql.ErrNotFound:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return &lease, nil
+}
+
+func (s leaseStore) Break(ctx context.Context, key string) error {
+	return s.d.session.Query("DELETE FROM "+s.d.lockTable()+" WHERE lockKey = ?", key).WithContext(ctx).Exec()
 }
 
 func (driver *Driver) FilenameExtension() string {
 	return "cql"
 }
 
-func (driver *Driver) version(d direction.Direction, invert bool) error {
+func (driver *Driver) version(ctx context.Context, d direction.Direction, invert bool) error {
 	var stmt counterStmt
 	switch d {
 	case direction.Up:
@@ -123,15 +244,20 @@ func (driver *Driver) version(d direction.Direction, invert bool) error {
 	if invert {
 		stmt = !stmt
 	}
-	return driver.session.Query(stmt.String(), versionRow).Exec()
+	return driver.session.Query(stmt.query(driver.migrationsTable), versionRow).WithContext(ctx).Exec()
 }
 
 func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+func (driver *Driver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
 	var err error
 	defer func() {
 		if err != nil {
 			// Invert version direction if we couldn't apply the changes for some reason.
-			if err := driver.version(f.Direction, true); err != nil {
+			// The context might be done already, so this must not depend on it.
+			if err := driver.version(context.Background(), f.Direction, true); err != nil {
 				pipe <- err
 			}
 			pipe <- err
@@ -140,7 +266,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 	}()
 
 	pipe <- f
-	if err = driver.version(f.Direction, false); err != nil {
+	if err = driver.version(ctx, f.Direction, false); err != nil {
 		return
 	}
 
@@ -154,19 +280,86 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 			continue
 		}
 
-		if err = driver.session.Query(query).Exec(); err != nil {
+		if err = driver.session.Query(query).WithContext(ctx).Exec(); err != nil {
 			return
 		}
 	}
 }
 
 func (driver *Driver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *Driver) VersionContext(ctx context.Context) (uint64, error) {
 	var version int64
-	err := driver.session.Query("SELECT version FROM "+tableName+" WHERE versionRow = ?", versionRow).Scan(&version)
+	err := driver.session.Query("SELECT version FROM "+driver.migrationsTable+" WHERE versionRow = ?", versionRow).WithContext(ctx).Scan(&version)
 	return uint64(version) - 1, err
 }
 
+func (driver *Driver) SetDirty(ctx context.Context, f file.File) error {
+	return driver.session.Query("INSERT INTO "+driver.dirtyTable()+" (dirtyRow, version, name, file_name, direction) VALUES (?, ?, ?, ?, ?)",
+		dirtyRow, int64(f.Version), f.Name, f.FileName, f.Direction.String()).WithContext(ctx).Exec()
+}
+
+func (driver *Driver) ClearDirty(ctx context.Context) error {
+	return driver.session.Query("DELETE FROM "+driver.dirtyTable()+" WHERE dirtyRow = ?", dirtyRow).WithContext(ctx).Exec()
+}
+
+func (driver *Driver) Dirty(ctx context.Context) (*file.File, error) {
+	var version int64
+	var name, fileName, d string
+	err := driver.session.Query("SELECT version, name, file_name, direction FROM "+driver.dirtyTable()+" WHERE dirtyRow = ?", dirtyRow).WithContext(ctx).Scan(&version, &name, &fileName, &d)
+	switch {
+	case err == gocql.ErrNotFound:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	dir, err := direction.Parse(d)
+	if err != nil {
+		return nil, err
+	}
+	return &file.File{Version: uint64(version), Name: name, FileName: fileName, Direction: dir}, nil
+}
+
+// Force moves the version counter to version. Like the counter itself,
+// this assumes that versions are numbered without gaps.
+func (driver *Driver) Force(ctx context.Context, version uint64) error {
+	current, err := driver.VersionContext(ctx)
+	if err != nil {
+		return err
+	}
+	delta := int64(version) - int64(current)
+	if delta != 0 {
+		if err := driver.session.Query("UPDATE "+driver.migrationsTable+" SET version = version + ? WHERE versionRow = ?", delta, versionRow).WithContext(ctx).Exec(); err != nil {
+			return err
+		}
+	}
+	return driver.ClearDirty(ctx)
+}
+
+// Baseline moves the version counter to the version of the last file.
+func (d *Driver) Baseline(ctx context.Context, files file.Files) error {
+	current, err := d.VersionContext(ctx)
+	if err != nil {
+		return err
+	}
+	if current != 0 {
+		return driver.ErrAlreadyMigrated
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return d.session.Query("UPDATE "+d.migrationsTable+" SET version = version + ? WHERE versionRow = ?", int64(files[len(files)-1].Version), versionRow).WithContext(ctx).Exec()
+}
+
 func init() {
 	driver.RegisterDriver("cassandra", driver.NewDriverGenerator(
-		func() driver.Driver { return &Driver{} }))
+		func() driver.Driver { return &Driver{} }).WithInfo(driver.Info{
+		FilenameExtension: "cql",
+		Description:       "Apache Cassandra",
+		URLFormat:         "cassandra://host:port/keyspace",
+
+		SequentialVersionsOnly: true,
+	}))
 }
@@ -2,14 +2,18 @@
 package driver
 
 import (
+	"context"
 	"fmt"
 	neturl "net/url" // alias to allow `url string` func signature in New
+	"time"
 
 	"github.com/jfrog/go-dbmigrate/file"
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
 )
 
 var (
 	ErrLocked                  = fmt.Errorf("can't acquire lock")
+	ErrAlreadyMigrated         = fmt.Errorf("migrations have already been applied")
 	ErrFailedToSendCloseNotify = fmt.Errorf("failed to send closeNotify alert, please see https://github.com/jackc/pgx/issues/984 for more details")
 )
 
@@ -39,9 +43,173 @@ type Driver interface {
 	Version() (uint64, error)
 }
 
+// ContextDriver is an optional interface that may be implemented by a Driver
+// to support cancellation. Drivers that don't implement it are still usable
+// with the context-aware functions of package migrate, but a running
+// migration can't be aborted then.
+type ContextDriver interface {
+	Driver
+
+	// InitializeContext is like Initialize, but gives up connecting
+	// once ctx is done.
+	InitializeContext(ctx context.Context, url string, initOptions ...func(Driver)) error
+
+	// MigrateContext is like Migrate, but passes ctx down to all
+	// calls made to the backend, so that the migration is aborted
+	// once ctx is done.
+	MigrateContext(ctx context.Context, file file.File, pipe chan interface{})
+
+	// VersionContext is like Version.
+	VersionContext(ctx context.Context) (uint64, error)
+}
+
+// AppliedVersionsDriver is an optional interface that may be implemented
+// by a Driver which keeps a record of every applied version, not only
+// of the most recent one. It allows package migrate to find migrations
+// that have been added below the current version.
+type AppliedVersionsDriver interface {
+	Driver
+
+	// AppliedVersions returns all applied versions in ascending order.
+	AppliedVersions(ctx context.Context) ([]uint64, error)
+}
+
+// ChecksumDriver is an optional interface that may be implemented by a
+// Driver which stores the checksum (see file.File.Checksum) of every
+// applied up migration file. It allows package migrate to detect files
+// that have been edited after they were applied.
+type ChecksumDriver interface {
+	Driver
+
+	// Checksums returns the stored checksums of all applied versions.
+	// Versions applied before checksums were stored have an empty checksum.
+	Checksums(ctx context.Context) (map[uint64]string, error)
+
+	// SetChecksum replaces the stored checksum of an applied version.
+	SetChecksum(ctx context.Context, version uint64, checksum string) error
+}
+
+// HistoryDriver is an optional interface that may be implemented by a
+// Driver which keeps a history of all migration files that have been
+// applied, including the ones that have been rolled back since.
+type HistoryDriver interface {
+	Driver
+
+	// AddHistory appends entry to the history.
+	AddHistory(ctx context.Context, entry HistoryEntry) error
+
+	// History returns the whole history, oldest entry first.
+	History(ctx context.Context) ([]HistoryEntry, error)
+}
+
+// HistoryEntry describes one migration file that has been applied.
+type HistoryEntry struct {
+	Version   uint64              `json:"version"`
+	Name      string              `json:"name"`
+	Direction direction.Direction `json:"direction"`
+
+	AppliedAt time.Time     `json:"applied_at"`
+	Duration  time.Duration `json:"duration"`
+
+	// the host the migration was applied from
+	Hostname string `json:"hostname"`
+
+	// the version of the migrate tool, if known
+	ToolVersion string `json:"tool_version"`
+
+	// an optional label supplied by the caller, e.g. a git SHA
+	Label string `json:"label"`
+}
+
+// DirtyDriver is an optional interface that may be implemented by a
+// Driver whose migrations can fail halfway, e.g. because they don't run
+// in a transaction. Package migrate marks the database as dirty before
+// it applies a migration file and clears the flag once the file has been
+// applied. It refuses to migrate a dirty database.
+type DirtyDriver interface {
+	Driver
+
+	// SetDirty records that f is about to be applied.
+	SetDirty(ctx context.Context, f file.File) error
+
+	// ClearDirty clears the dirty flag.
+	ClearDirty(ctx context.Context) error
+
+	// Dirty returns the file passed to SetDirty, without its content,
+	// or nil if the dirty flag is clear.
+	Dirty(ctx context.Context) (*file.File, error)
+
+	// Force sets the current version to version and clears the dirty
+	// flag, without applying anything.
+	Force(ctx context.Context, version uint64) error
+}
+
+// BaselineDriver is an optional interface that may be implemented by a
+// Driver to adopt a database whose schema has been created by other means.
+type BaselineDriver interface {
+	Driver
+
+	// Baseline records the up migration files as applied, without
+	// applying them. It fails with ErrAlreadyMigrated if any version
+	// has been recorded before.
+	Baseline(ctx context.Context, files file.Files) error
+}
+
+// Locker is an optional interface that may be implemented by a Driver to
+// prevent concurrent migrations of the same database. Package migrate
+// holds the lock from reading the version until the last migration file
+// has been applied.
+type Locker interface {
+	// Lock acquires the migration lock. If it's held by someone else,
+	// Lock waits up to timeout for it and returns ErrLocked then.
+	// A timeout of 0 waits until ctx is done.
+	Lock(ctx context.Context, timeout time.Duration) error
+
+	// Unlock releases the migration lock. It does nothing if the
+	// lock isn't held.
+	Unlock() error
+}
+
 type DriverGenerator struct {
 	fnGenerator   func() Driver
 	fnInitOptions []func(Driver)
+	info          Info
+}
+
+// Info is the static metadata of a driver. It's available without
+// initializing the driver, e.g. to create migration files offline.
+type Info struct {
+	// FilenameExtension is the extension of the migration files, like
+	// Driver.FilenameExtension.
+	FilenameExtension string
+
+	// Description is a short description of the driver.
+	Description string
+
+	// URLFormat shows the format of the driver's urls.
+	URLFormat string
+
+	// SequentialVersionsOnly is set by drivers that count the version up
+	// and down by one per migration file, so they can't store timestamp
+	// versions.
+	SequentialVersionsOnly bool
+
+	// Stubs generates the migration methods of drivers that migrate with
+	// go methods. It's nil for all other drivers.
+	Stubs MethodStubs
+}
+
+// MethodStubs generates the migration methods of a driver that migrates
+// with go methods, see package gomethods.
+type MethodStubs interface {
+	// MethodName returns the name of the migration method of the
+	// migration file versionString_name.d.
+	MethodName(versionString, name string, d direction.Direction) (string, error)
+
+	// AppendStubs appends stubs of methods with receiver to the Go file
+	// filename. Empty receiver means the receiver of the existing
+	// migration methods.
+	AppendStubs(filename, receiver string, methods []string) error
 }
 
 func NewDriverGenerator(fn func() Driver) *DriverGenerator {
@@ -50,6 +218,12 @@ func NewDriverGenerator(fn func() Driver) *DriverGenerator {
 	}
 }
 
+// WithInfo sets the static metadata of the driver and returns dg.
+func (dg *DriverGenerator) WithInfo(info Info) *DriverGenerator {
+	dg.info = info
+	return dg
+}
+
 func (dg *DriverGenerator) RegisterInitFunction(fnInit func(Driver)) {
 	dg.fnInitOptions = append(dg.fnInitOptions, fnInit)
 }
@@ -64,6 +238,15 @@ func (dg *DriverGenerator) Generate() Driver {
 
 // New returns Driver and calls Initialize on it
 func New(url string, initOptions ...func(Driver)) (Driver, error) {
+	return NewContext(context.Background(), url, initOptions...)
+}
+
+// NewContext is like New, but calls InitializeContext on drivers
+// implementing ContextDriver.
+func NewContext(ctx context.Context, url string, initOptions ...func(Driver)) (Driver, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	u, err := neturl.Parse(url)
 	if err != nil {
 		return nil, err
@@ -75,13 +258,62 @@ func New(url string, initOptions ...func(Driver)) (Driver, error) {
 	}
 	d := gen.Generate()
 	verifyFilenameExtension(u.Scheme, d)
-	if err := d.Initialize(url, initOptions...); err != nil {
+	if ext := gen.info.FilenameExtension; ext != "" && ext != d.FilenameExtension() {
+		panic(fmt.Sprintf("%s.FilenameExtension() returns %q, but the driver is registered with %q.", u.Scheme, d.FilenameExtension(), ext))
+	}
+	if cd, ok := d.(ContextDriver); ok {
+		err = cd.InitializeContext(ctx, url, initOptions...)
+	} else {
+		err = d.Initialize(url, initOptions...)
+	}
+	if err != nil {
 		return nil, err
 	}
 
 	return d, nil
 }
 
+// MigrateContext calls MigrateContext on drivers implementing ContextDriver
+// and falls back to Migrate otherwise.
+func MigrateContext(ctx context.Context, d Driver, f file.File, pipe chan interface{}) {
+	if cd, ok := d.(ContextDriver); ok {
+		cd.MigrateContext(ctx, f, pipe)
+		return
+	}
+	d.Migrate(f, pipe)
+}
+
+// VersionContext calls VersionContext on drivers implementing ContextDriver
+// and falls back to Version otherwise.
+func VersionContext(ctx context.Context, d Driver) (uint64, error) {
+	if cd, ok := d.(ContextDriver); ok {
+		return cd.VersionContext(ctx)
+	}
+	return d.Version()
+}
+
+// AppliedVersions calls AppliedVersions on drivers implementing
+// AppliedVersionsDriver. ok is false for all other drivers.
+func AppliedVersions(ctx context.Context, d Driver) (versions []uint64, ok bool, err error) {
+	avd, ok := d.(AppliedVersionsDriver)
+	if !ok {
+		return nil, false, nil
+	}
+	versions, err = avd.AppliedVersions(ctx)
+	return versions, true, err
+}
+
+// Checksums calls Checksums on drivers implementing ChecksumDriver.
+// ok is false for all other drivers.
+func Checksums(ctx context.Context, d Driver) (checksums map[uint64]string, ok bool, err error) {
+	cd, ok := d.(ChecksumDriver)
+	if !ok {
+		return nil, false, nil
+	}
+	checksums, err = cd.Checksums(ctx)
+	return checksums, true, err
+}
+
 // verifyFilenameExtension panics if the driver's filename extension
 // is not correct or empty.
 func verifyFilenameExtension(driverName string, d Driver) {
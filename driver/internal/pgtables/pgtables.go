@@ -0,0 +1,242 @@
+// Package pgtables implements the bookkeeping of the drivers that store
+// the applied migrations in a PostgreSQL database, postgres and generic.
+package pgtables
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jfrog/go-dbmigrate/driver"
+	"github.com/jfrog/go-dbmigrate/file"
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+// Tables stores the applied migration versions and their checksums in the
+// migrations table, and the history in <migrations table>_history. Drivers
+// embed it for its driver.Driver, driver.ChecksumDriver,
+// driver.HistoryDriver and driver.BaselineDriver methods.
+type Tables struct {
+	conn            func(ctx context.Context) (*sql.DB, error)
+	migrationsTable string
+}
+
+// New returns the Tables of migrationsTable. conn returns the connection
+// pool of the driver, reconnecting if needed.
+func New(conn func(ctx context.Context) (*sql.DB, error), migrationsTable string) *Tables {
+	return &Tables{conn: conn, migrationsTable: migrationsTable}
+}
+
+// HistoryTable returns the name of the history table.
+func (t *Tables) HistoryTable() string {
+	return t.migrationsTable + "_history"
+}
+
+// Ensure creates the tables if they don't exist and upgrades tables
+// created by earlier versions.
+func (t *Tables) Ensure(ctx context.Context) error {
+	db, err := t.conn(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+t.migrationsTable+" (version bigint not null primary key);"); err != nil {
+		return err
+	}
+	if err := t.ensureBigintVersion(ctx, db); err != nil {
+		return err
+	}
+	// tables created before checksums were stored don't have the column yet
+	if _, err := db.ExecContext(ctx, "ALTER TABLE "+t.migrationsTable+" ADD COLUMN IF NOT EXISTS checksum varchar(64) not null default ''"); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+t.HistoryTable()+" (id serial primary key, version bigint not null, name varchar(255) not null, direction varchar(4) not null, applied_at timestamp with time zone not null, duration_ms bigint not null, hostname varchar(255) not null, tool_version varchar(64) not null, label varchar(255) not null);"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpToDate reports whether Ensure has nothing to do, i.e. the tables,
+// along with the given other tables, exist and need no upgrade.
+func (t *Tables) UpToDate(ctx context.Context, tables ...string) (bool, error) {
+	db, err := t.conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var versionType sql.NullString
+	var hasChecksum bool
+	err = db.QueryRowContext(ctx, "SELECT (SELECT format_type(atttypid, atttypmod) FROM pg_attribute WHERE attrelid = to_regclass($1) AND attname = 'version' AND NOT attisdropped), EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = to_regclass($1) AND attname = 'checksum' AND NOT attisdropped)", t.migrationsTable).
+		Scan(&versionType, &hasChecksum)
+	if err != nil || versionType.String != "bigint" || !hasChecksum {
+		return false, err
+	}
+	for _, table := range append([]string{t.HistoryTable()}, tables...) {
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil || !exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ensureBigintVersion widens the version column of tables created with
+// an int column, which can't hold timestamp versions.
+func (t *Tables) ensureBigintVersion(ctx context.Context, db *sql.DB) error {
+	var dataType string
+	err := db.QueryRowContext(ctx, "SELECT format_type(atttypid, atttypmod) FROM pg_attribute WHERE attrelid = $1::regclass AND attname = 'version'", t.migrationsTable).Scan(&dataType)
+	if err != nil || dataType != "integer" {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "ALTER TABLE "+t.migrationsTable+" ALTER COLUMN version TYPE bigint")
+	return err
+}
+
+func (t *Tables) Version() (uint64, error) {
+	return t.VersionContext(context.Background())
+}
+
+func (t *Tables) VersionContext(ctx context.Context) (uint64, error) {
+	db, err := t.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var version uint64
+	err = db.QueryRowContext(ctx, "SELECT version FROM "+t.migrationsTable+" ORDER BY version DESC LIMIT 1").Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, nil
+	case err != nil:
+		return 0, err
+	default:
+		return version, nil
+	}
+}
+
+func (t *Tables) AppliedVersions(ctx context.Context) ([]uint64, error) {
+	db, err := t.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version FROM "+t.migrationsTable+" ORDER BY version ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := make([]uint64, 0)
+	for rows.Next() {
+		var version uint64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (t *Tables) Checksums(ctx context.Context) (map[uint64]string, error) {
+	db, err := t.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, checksum FROM "+t.migrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	checksums := make(map[uint64]string)
+	for rows.Next() {
+		var version uint64
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[version] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+func (t *Tables) SetChecksum(ctx context.Context, version uint64, checksum string) error {
+	db, err := t.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE "+t.migrationsTable+" SET checksum = $1 WHERE version = $2", checksum, version)
+	return err
+}
+
+func (t *Tables) AddHistory(ctx context.Context, entry driver.HistoryEntry) error {
+	db, err := t.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO "+t.HistoryTable()+" (version, name, direction, applied_at, duration_ms, hostname, tool_version, label) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		entry.Version, entry.Name, entry.Direction.String(), entry.AppliedAt, entry.Duration.Milliseconds(), entry.Hostname, entry.ToolVersion, entry.Label)
+	return err
+}
+
+func (t *Tables) History(ctx context.Context) ([]driver.HistoryEntry, error) {
+	db, err := t.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, name, direction, applied_at, duration_ms, hostname, tool_version, label FROM "+t.HistoryTable()+" ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := make([]driver.HistoryEntry, 0)
+	for rows.Next() {
+		var entry driver.HistoryEntry
+		var dir string
+		var appliedAt time.Time
+		var durationMs int64
+		if err := rows.Scan(&entry.Version, &entry.Name, &dir, &appliedAt, &durationMs, &entry.Hostname, &entry.ToolVersion, &entry.Label); err != nil {
+			return nil, err
+		}
+		if entry.Direction, err = direction.Parse(dir); err != nil {
+			return nil, err
+		}
+		entry.AppliedAt = appliedAt
+		entry.Duration = time.Duration(durationMs) * time.Millisecond
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+func (t *Tables) Baseline(ctx context.Context, files file.Files) error {
+	db, err := t.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+t.migrationsTable).Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return driver.ErrAlreadyMigrated
+	}
+	for _, f := range files {
+		checksum, err := f.Checksum()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+t.migrationsTable+" (version, checksum) VALUES ($1, $2)", f.Version, checksum); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
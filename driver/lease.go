@@ -0,0 +1,311 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTTL is how long a lease is valid unless it's renewed.
+const DefaultLeaseTTL = 30 * time.Second
+
+var (
+	// ErrNoLease is returned by the LeaseDriver methods of drivers that
+	// have been configured to use their native lock instead of a lease.
+	ErrNoLease = errors.New("the migration lock is not a lease lock")
+
+	// ErrLeaseLost is returned by LeaseLock.Unlock if the lease expired or
+	// was broken while it was held, so others may have migrated meanwhile.
+	ErrLeaseLost = errors.New("the lease of the migration lock was lost while it was held")
+)
+
+// Lease is a lock that expires unless its owner renews it.
+type Lease struct {
+	Key        string    `json:"key"`
+	Owner      string    `json:"owner"`
+	Hostname   string    `json:"hostname"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Expired reports whether the lease has expired at now.
+func (l Lease) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// LeaseStore stores the leases of LeaseLocks, one per key.
+type LeaseStore interface {
+	// Acquire stores lease unless an unexpired lease for its key is
+	// stored at now. It returns whether lease has been stored.
+	Acquire(ctx context.Context, lease Lease, now time.Time) (bool, error)
+
+	// Renew replaces the stored lease by lease, e.g. with a later expiry,
+	// if it still belongs to the owner of lease. It returns false if the
+	// lease has been lost.
+	Renew(ctx context.Context, lease Lease) (bool, error)
+
+	// Release removes the lease of key if it belongs to owner.
+	Release(ctx context.Context, key, owner string) error
+
+	// Get returns the lease of key, expired or not, or nil if there's none.
+	Get(ctx context.Context, key string) (*Lease, error)
+
+	// Break removes the lease of key, whoever it belongs to.
+	Break(ctx context.Context, key string) error
+}
+
+// LeaseDriver is an optional interface that may be implemented by a
+// Driver whose migration lock is a lease, so that a stale lease can be
+// inspected and broken.
+type LeaseDriver interface {
+	// Lease returns the lease of the migration lock, or nil if the
+	// lock isn't held.
+	Lease(ctx context.Context) (*Lease, error)
+
+	// BreakLease removes the lease of the migration lock, whoever holds it.
+	BreakLease(ctx context.Context) error
+}
+
+// LeaseLostNotifier is an optional interface that may be implemented by a
+// Driver whose migration lock is a lease, so that migrations stop once the
+// lease is lost.
+type LeaseLostNotifier interface {
+	// LeaseLost returns a channel that's closed once the lease of the
+	// held migration lock is lost. It returns nil if the driver uses its
+	// native lock.
+	LeaseLost() <-chan struct{}
+}
+
+// LeaseLock is a Locker for drivers without a native lock, or whose native
+// lock doesn't work in their environment, e.g. session advisory locks
+// behind a connection pooler. It stores a lease in a LeaseStore and renews
+// it in the background while it's held, so the lease of a crashed owner
+// expires after the TTL.
+type LeaseLock struct {
+	store LeaseStore
+	key   string
+	ttl   time.Duration
+
+	lease     *Lease
+	stop      chan struct{}
+	done      chan struct{}
+	lostNotif chan struct{}
+
+	mu         sync.Mutex
+	lost       bool
+	validUntil time.Time
+}
+
+// NewLeaseLock returns a LeaseLock for the lease of key in store. A ttl
+// of 0 means DefaultLeaseTTL.
+func NewLeaseLock(store LeaseStore, key string, ttl time.Duration) *LeaseLock {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	return &LeaseLock{store: store, key: key, ttl: ttl}
+}
+
+func (l *LeaseLock) Lock(ctx context.Context, timeout time.Duration) error {
+	if l.lease != nil {
+		return ErrLocked
+	}
+
+	hostname, _ := os.Hostname()
+	owner := NewLockOwner()
+	var lease Lease
+	err := PollLock(ctx, timeout, func(ctx context.Context) (bool, error) {
+		now := time.Now()
+		lease = Lease{Key: l.key, Owner: owner, Hostname: hostname, AcquiredAt: now, ExpiresAt: now.Add(l.ttl)}
+		return l.store.Acquire(ctx, lease, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	l.lease = &lease
+	l.lost = false
+	l.validUntil = lease.ExpiresAt
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	l.lostNotif = make(chan struct{})
+	go l.heartbeat(lease, l.stop, l.done, l.lostNotif)
+	return nil
+}
+
+// heartbeat renews lease every third of the TTL until stop is closed.
+// Failed renewals are retried, the lease stays valid until it expires
+// one TTL after the last successful renewal. lost is closed once the
+// lease is lost.
+func (l *LeaseLock) heartbeat(lease Lease, stop, done, lost chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		lease.ExpiresAt = time.Now().Add(l.ttl)
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+		renewed, err := l.store.Renew(ctx, lease)
+		cancel()
+
+		l.mu.Lock()
+		switch {
+		case err == nil && renewed:
+			l.validUntil = lease.ExpiresAt
+		case err == nil || !time.Now().Before(l.validUntil):
+			// taken over by someone else, or expired while the
+			// store couldn't be reached
+			l.lost = true
+		}
+		isLost := l.lost
+		l.mu.Unlock()
+		if isLost {
+			close(lost)
+			return
+		}
+	}
+}
+
+// Unlock stops renewing the lease and releases it. It returns ErrLeaseLost
+// if the lease has been lost while it was held.
+func (l *LeaseLock) Unlock() error {
+	if l.lease == nil {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+	lease := l.lease
+	l.lease = nil
+
+	l.mu.Lock()
+	lost := l.lost || !time.Now().Before(l.validUntil)
+	l.mu.Unlock()
+	if lost {
+		return ErrLeaseLost
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl)
+	defer cancel()
+	return l.store.Release(ctx, lease.Key, lease.Owner)
+}
+
+// Lost returns a channel that's closed once the lease is lost while it's
+// held, i.e. it has expired or has been taken over. It's nil while the
+// lock isn't held.
+func (l *LeaseLock) Lost() <-chan struct{} {
+	if l.lease == nil {
+		return nil
+	}
+	return l.lostNotif
+}
+
+// Lease returns the lease of the lock, or nil if it isn't held.
+func (l *LeaseLock) Lease(ctx context.Context) (*Lease, error) {
+	return l.store.Get(ctx, l.key)
+}
+
+// BreakLease removes the lease of the lock, whoever holds it.
+func (l *LeaseLock) BreakLease(ctx context.Context) error {
+	return l.store.Break(ctx, l.key)
+}
+
+// SQLLeaseStore is a LeaseStore in a table of a SQL database, for drivers
+// based on database/sql. Times are stored as milliseconds since the epoch
+// of the clock of the client, so clients must keep their clocks in sync.
+type SQLLeaseStore struct {
+	DB    *sql.DB
+	Table string
+
+	// DollarPlaceholders makes queries use $1, $2, ... instead of ?,
+	// as PostgreSQL requires.
+	DollarPlaceholders bool
+}
+
+// CreateTable creates the table of the leases if it doesn't exist.
+func (s *SQLLeaseStore) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+s.Table+" (lock_key varchar(255) not null primary key, owner varchar(255) not null, hostname varchar(255) not null, acquired_at bigint not null, expires_at bigint not null)")
+	return err
+}
+
+func (s *SQLLeaseStore) Acquire(ctx context.Context, lease Lease, now time.Time) (bool, error) {
+	if _, err := s.DB.ExecContext(ctx, s.query("DELETE FROM "+s.Table+" WHERE lock_key = ? AND expires_at <= ?"), lease.Key, millis(now)); err != nil {
+		return false, err
+	}
+	_, err := s.DB.ExecContext(ctx, s.query("INSERT INTO "+s.Table+" (lock_key, owner, hostname, acquired_at, expires_at) VALUES (?, ?, ?, ?, ?)"),
+		lease.Key, lease.Owner, lease.Hostname, millis(lease.AcquiredAt), millis(lease.ExpiresAt))
+	if err == nil {
+		return true, nil
+	}
+	// duplicate key errors differ between databases, so look
+	// whether the insert failed because someone else holds the lease
+	current, getErr := s.Get(ctx, lease.Key)
+	if getErr == nil && current != nil && current.Owner != lease.Owner {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *SQLLeaseStore) Renew(ctx context.Context, lease Lease) (bool, error) {
+	result, err := s.DB.ExecContext(ctx, s.query("UPDATE "+s.Table+" SET expires_at = ? WHERE lock_key = ? AND owner = ?"), millis(lease.ExpiresAt), lease.Key, lease.Owner)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQLLeaseStore) Release(ctx context.Context, key, owner string) error {
+	_, err := s.DB.ExecContext(ctx, s.query("DELETE FROM "+s.Table+" WHERE lock_key = ? AND owner = ?"), key, owner)
+	return err
+}
+
+func (s *SQLLeaseStore) Get(ctx context.Context, key string) (*Lease, error) {
+	lease := Lease{Key: key}
+	var acquiredAt, expiresAt int64
+	err := s.DB.QueryRowContext(ctx, s.query("SELECT owner, hostname, acquired_at, expires_at FROM "+s.Table+" WHERE lock_key = ?"), key).
+		Scan(&lease.Owner, &lease.Hostname, &acquiredAt, &expiresAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	lease.AcquiredAt = time.Unix(0, acquiredAt*int64(time.Millisecond))
+	lease.ExpiresAt = time.Unix(0, expiresAt*int64(time.Millisecond))
+	return &lease, nil
+}
+
+func (s *SQLLeaseStore) Break(ctx context.Context, key string) error {
+	_, err := s.DB.ExecContext(ctx, s.query("DELETE FROM "+s.Table+" WHERE lock_key = ?"), key)
+	return err
+}
+
+// query replaces the ? placeholders of q if DollarPlaceholders is set.
+func (s *SQLLeaseStore) query(q string) string {
+	if !s.DollarPlaceholders {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,86 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unreachableStore is a LeaseStore whose Renew fails once unreachable is
+// set, like a database that can't be reached.
+type unreachableStore struct {
+	mu          sync.Mutex
+	unreachable bool
+	released    bool
+}
+
+func (s *unreachableStore) Acquire(ctx context.Context, lease Lease, now time.Time) (bool, error) {
+	return true, nil
+}
+
+func (s *unreachableStore) Renew(ctx context.Context, lease Lease) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unreachable {
+		return false, errors.New("connection refused")
+	}
+	return true, nil
+}
+
+func (s *unreachableStore) Release(ctx context.Context, key, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.released = true
+	return nil
+}
+
+func (s *unreachableStore) Get(ctx context.Context, key string) (*Lease, error) { return nil, nil }
+func (s *unreachableStore) Break(ctx context.Context, key string) error         { return nil }
+
+func TestLeaseLockExpiresWhileUnreachable(t *testing.T) {
+	ttl := 60 * time.Millisecond
+	ctx := context.Background()
+
+	// failed renewals within the TTL are retried
+	store := &unreachableStore{}
+	l := NewLeaseLock(store, "test", ttl)
+	if err := l.Lock(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(ttl / 2)
+	store.mu.Lock()
+	store.unreachable = true
+	store.mu.Unlock()
+	time.Sleep(ttl / 2)
+	select {
+	case <-l.Lost():
+		t.Fatal("Expected the lease not to be lost yet")
+	default:
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("Expected the lease to be released, got %v", err)
+	}
+	if !store.released {
+		t.Error("Expected the lease to be released")
+	}
+
+	// the lease expires once the store is unreachable for the TTL
+	store = &unreachableStore{unreachable: true}
+	l = NewLeaseLock(store, "test", ttl)
+	if err := l.Lock(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-l.Lost():
+	case <-time.After(3 * ttl):
+		t.Fatal("Expected the lease to be lost")
+	}
+	if err := l.Unlock(); err != ErrLeaseLost {
+		t.Fatalf("Expected ErrLeaseLost, got %v", err)
+	}
+	if store.released {
+		t.Error("Expected a lost lease not to be released")
+	}
+}
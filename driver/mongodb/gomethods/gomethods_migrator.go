@@ -1,12 +1,11 @@
 package gomethods
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"github.com/jfrog/go-dbmigrate/driver"
+	"github.com/jfrog/go-dbmigrate/event"
 	"github.com/jfrog/go-dbmigrate/file"
-	"os"
-	"path"
 	"strings"
 )
 
@@ -54,6 +53,12 @@ type Migrator struct {
 }
 
 func (m *Migrator) Migrate(f file.File, pipe chan interface{}) error {
+	return m.MigrateContext(context.Background(), f, pipe)
+}
+
+// MigrateContext is like Migrate, but doesn't invoke any further methods
+// once ctx is done. Cancellation is handled like a failing method.
+func (m *Migrator) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) error {
 	methods, err := m.getMigrationMethods(f)
 	if err != nil {
 		pipe <- err
@@ -61,8 +66,11 @@ func (m *Migrator) Migrate(f file.File, pipe chan interface{}) error {
 	}
 
 	for i, methodName := range methods {
-		pipe <- methodName
-		err := m.invokeMethodWithRecoverFromPanic(methodName)
+		err := ctx.Err()
+		if err == nil {
+			pipe <- event.MethodInvoked{Method: methodName}
+			err = m.invokeMethodWithRecoverFromPanic(methodName)
+		}
 		if err != nil {
 			pipe <- err
 			if !m.RollbackOnFailure {
@@ -79,7 +87,7 @@ func (m *Migrator) Migrate(f file.File, pipe chan interface{}) error {
 					continue
 				}
 
-				pipe <- rollbackToMethodName
+				pipe <- event.MethodInvoked{Method: rollbackToMethodName}
 				err = m.invokeMethodWithRecoverFromPanic(rollbackToMethodName)
 				if err != nil {
 					pipe <- err
@@ -117,24 +125,10 @@ func getRollbackToMethod(methodName string) string {
 }
 
 func getFileLines(file file.File) ([]string, error) {
-	if len(file.Content) == 0 {
-		lines := make([]string, 0)
-		file, err := os.Open(path.Join(file.Path, file.FileName))
-		if err != nil {
-			return nil, err
-		}
-		defer file.Close()
-
-		scanner := bufio.NewScanner(file)
-
-		for scanner.Scan() {
-			lines = append(lines, scanner.Text())
-		}
-		return lines, nil
-	} else {
-		s := string(file.Content)
-		return strings.Split(s, "\n"), nil
+	if err := file.ReadContent(); err != nil {
+		return nil, err
 	}
+	return strings.Split(string(file.Content), "\n"), nil
 }
 
 func (m *Migrator) getMigrationMethods(f file.File) (methods []string, err error) {
@@ -0,0 +1,161 @@
+package gomethods
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jfrog/go-dbmigrate/driver"
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+// Signature describes the migration methods of a go methods driver, so
+// that stubs of them can be generated. Drivers register it as the
+// driver.Info Stubs.
+type Signature struct {
+	// Params are the parameters of the migration methods,
+	// e.g. "session *mgo.Session". They all return an error.
+	Params string
+
+	// ImportPath is the path of the package used by Params, if any,
+	// e.g. "gopkg.in/mgo.v2".
+	ImportPath string
+
+	// ImportName is the name of that package in Params, e.g. "mgo".
+	ImportName string
+}
+
+var _ driver.MethodStubs = Signature{}
+
+// MethodName implements driver.MethodStubs, see MethodName.
+func (s Signature) MethodName(versionString, name string, d direction.Direction) (string, error) {
+	return MethodName(versionString, name, d)
+}
+
+// AppendStubs implements driver.MethodStubs, see AppendStubs.
+func (s Signature) AppendStubs(filename, receiver string, methods []string) error {
+	return AppendStubs(filename, receiver, s, methods)
+}
+
+// methodNameRegex matches migration methods named by MethodName.
+var methodNameRegex = regexp.MustCompile(`^V[0-9]+_.*_(up|down)$`)
+
+// MethodName returns the name of the migration method of the migration
+// file versionString_name.d, e.g. V0002_add_users_up.
+func MethodName(versionString, name string, d direction.Direction) (string, error) {
+	methodName := fmt.Sprintf("V%s_%s_%s", versionString, name, d)
+	if !token.IsIdentifier(methodName) {
+		return "", fmt.Errorf("%s is not a valid method name, please use letters, digits and underscores in the name", methodName)
+	}
+	return methodName, nil
+}
+
+// AppendStubs appends stubs of methods with signature to the Go file
+// filename. If filename doesn't exist yet, it's created in the package of
+// its directory. receiver is the type of the methods receiver, e.g.
+// *Migrator. If it's empty, it's taken from the existing migration methods
+// of the package.
+func AppendStubs(filename, receiver string, signature Signature, methods []string) error {
+	fset := token.NewFileSet()
+	dir := filepath.Dir(filename)
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return err
+	}
+	if len(pkgs) > 1 {
+		return fmt.Errorf("Found more than one package in %s", dir)
+	}
+
+	pkgName := filepath.Base(dir)
+	recvName := "r"
+	for name, pkg := range pkgs {
+		pkgName = name
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 {
+					continue
+				}
+				for _, method := range methods {
+					if fn.Name.Name == method {
+						return fmt.Errorf("Method %s already exists in %s", method, fset.Position(fn.Pos()))
+					}
+				}
+				if receiver != "" || !methodNameRegex.MatchString(fn.Name.Name) {
+					continue
+				}
+				var buf bytes.Buffer
+				if err := printer.Fprint(&buf, fset, fn.Recv.List[0].Type); err != nil {
+					return err
+				}
+				receiver = buf.String()
+				if len(fn.Recv.List[0].Names) == 1 {
+					recvName = fn.Recv.List[0].Names[0].Name
+				}
+			}
+		}
+	}
+	if receiver == "" {
+		return fmt.Errorf("No migration methods in %s to take the receiver from, please specify it", dir)
+	}
+	if !token.IsIdentifier(pkgName) {
+		return fmt.Errorf("%s is not a valid package name, please create %s with a package clause", pkgName, filename)
+	}
+
+	src, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		src = []byte("package " + pkgName + "\n")
+	} else if err != nil {
+		return err
+	}
+	f, err := parser.ParseFile(fset, filename, src, parser.ImportsOnly)
+	if err != nil {
+		return err
+	}
+
+	params := signature.Params
+	if signature.ImportPath != "" {
+		imported := false
+		for _, spec := range f.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			if path != signature.ImportPath || (spec.Name != nil && spec.Name.Name == "_") {
+				continue
+			}
+			imported = true
+			if spec.Name != nil && spec.Name.Name == "." {
+				params = strings.Replace(params, signature.ImportName+".", "", -1)
+			} else if spec.Name != nil {
+				params = strings.Replace(params, signature.ImportName+".", spec.Name.Name+".", -1)
+			}
+			break
+		}
+		if !imported {
+			// a separate import declaration right after the package clause
+			end := fset.Position(f.Name.End()).Offset
+			src = append(src[:end:end], append([]byte("\n\nimport "+strconv.Quote(signature.ImportPath)), src[end:]...)...)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(src)
+	for _, method := range methods {
+		fmt.Fprintf(&buf, "\nfunc (%s %s) %s(%s) error {\n\treturn nil\n}\n", recvName, receiver, method, params)
+	}
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("Invalid stubs in %s: %v", filename, err)
+	}
+	return ioutil.WriteFile(filename, formatted, 0644)
+}
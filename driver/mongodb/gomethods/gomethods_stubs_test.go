@@ -0,0 +1,126 @@
+package gomethods
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+func TestMethodName(t *testing.T) {
+	tests := []struct {
+		versionString string
+		name          string
+		d             direction.Direction
+		expected      string
+		err           bool
+	}{
+		{"0002", "add_users", direction.Up, "V0002_add_users_up", false},
+		{"20240131154500", "add_users", direction.Down, "V20240131154500_add_users_down", false},
+		{"0002", "add-users", direction.Up, "", true},
+	}
+	for _, test := range tests {
+		methodName, err := MethodName(test.versionString, test.name, test.d)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error for %v", test.name)
+			}
+			continue
+		}
+		if err != nil || methodName != test.expected {
+			t.Errorf("Expected %v, got %v (%v)", test.expected, methodName, err)
+		}
+	}
+}
+
+func TestAppendStubs(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "gomethods-stubs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	mongo := Signature{Params: "session *mgo.Session", ImportPath: "gopkg.in/mgo.v2", ImportName: "mgo"}
+
+	// no migration methods to take the receiver from yet
+	if err := AppendStubs(path.Join(tmpdir, "v1.go"), "", Signature{}, []string{"V1_a_up"}); err == nil {
+		t.Error("Expected error without a receiver")
+	}
+
+	existing := `package migrations
+
+import (
+	mongo "gopkg.in/mgo.v2"
+)
+
+type Migrator struct{}
+
+func (m *Migrator) V1_a_up(session *mongo.Session) error {
+	return nil
+}
+`
+	if err := ioutil.WriteFile(path.Join(tmpdir, "v1.go"), []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filename  string
+		receiver  string
+		signature Signature
+		methods   []string
+		contains  []string
+		err       bool
+	}{
+		{
+			filename:  "v1.go",
+			signature: mongo,
+			methods:   []string{"V2_b_up", "V2_b_down"},
+			contains: []string{
+				"func (m *Migrator) V2_b_up(session *mongo.Session) error {\n\treturn nil\n}",
+				"func (m *Migrator) V2_b_down(session *mongo.Session) error {\n\treturn nil\n}",
+			},
+		},
+		{
+			filename:  "v3.go",
+			signature: mongo,
+			methods:   []string{"V3_c_up"},
+			contains: []string{
+				"package migrations\n\nimport \"gopkg.in/mgo.v2\"\n",
+				"func (m *Migrator) V3_c_up(session *mgo.Session) error {",
+			},
+		},
+		{
+			filename: "v4.go",
+			receiver: "Other",
+			methods:  []string{"V4_d_up"},
+			contains: []string{"func (r Other) V4_d_up() error {"},
+		},
+		{filename: "v5.go", methods: []string{"V2_b_up"}, err: true},
+		{filename: "missing/v5.go", methods: []string{"V5_e_up"}, err: true},
+	}
+	for _, test := range tests {
+		filename := path.Join(tmpdir, test.filename)
+		err := AppendStubs(filename, test.receiver, test.signature, test.methods)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error for %v", test.methods)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", test.methods, err)
+			continue
+		}
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range test.contains {
+			if !strings.Contains(string(content), s) {
+				t.Errorf("Expected %q in %v, got:\n%s", s, test.filename, content)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package mongodb
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -16,6 +17,7 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"time"
 )
 
 type UnregisteredMethodsReceiverError string
@@ -31,6 +33,13 @@ func (e WrongMethodsReceiverTypeError) Error() string {
 }
 
 const MIGRATE_C = "db_migrations"
+
+// dirtyId is the id of the only document of the dirtyCollection.
+const dirtyId = "dirty"
+
+// lockId is the id of the lock document in the lockCollection if no
+// lock key has been set.
+const lockId = "lock"
 const DRIVER_NAME = "gomethods.mongodb"
 
 type Driver struct {
@@ -39,6 +48,13 @@ type Driver struct {
 	migrator        gomethods.Migrator
 	Url             string
 	sslOptions      SSlOptions
+	dbName          string
+	ownsSession     bool
+	migrationsC     string
+	lockKey         string
+	lockTimeout     time.Duration
+	leaseTTL        time.Duration
+	lock            *driver.LeaseLock
 }
 
 var _ gomethods.GoMethodsDriver = (*Driver)(nil)
@@ -63,12 +79,50 @@ func (d *Driver) SetMethodsReceiver(r interface{}) error {
 
 func init() {
 	driver.RegisterDriver("mongodb", driver.NewDriverGenerator(
-		func() driver.Driver { return &Driver{} }))
+		func() driver.Driver { return &Driver{} }).WithInfo(driver.Info{
+		FilenameExtension: "mgo",
+		Description:       "Go methods on a MongoDB session",
+		URLFormat:         "mongodb://host:port/database",
+		Stubs: gomethods.Signature{
+			Params:     "session *mgo.Session",
+			ImportPath: "gopkg.in/mgo.v2",
+			ImportName: "mgo",
+		},
+	}))
 }
 
 type DbMigration struct {
-	Id      bson.ObjectId `bson:"_id,omitempty"`
-	Version uint64        `bson:"version"`
+	Id       bson.ObjectId `bson:"_id,omitempty"`
+	Version  uint64        `bson:"version"`
+	Checksum string        `bson:"checksum,omitempty"`
+}
+
+type DbHistoryEntry struct {
+	Id          bson.ObjectId `bson:"_id,omitempty"`
+	Version     uint64        `bson:"version"`
+	Name        string        `bson:"name"`
+	Direction   string        `bson:"direction"`
+	AppliedAt   time.Time     `bson:"applied_at"`
+	DurationMs  int64         `bson:"duration_ms"`
+	Hostname    string        `bson:"hostname"`
+	ToolVersion string        `bson:"tool_version"`
+	Label       string        `bson:"label"`
+}
+
+type DbDirtyMigration struct {
+	Id        string `bson:"_id"`
+	Version   uint64 `bson:"version"`
+	Name      string `bson:"name"`
+	FileName  string `bson:"file_name"`
+	Direction string `bson:"direction"`
+}
+
+type DbLock struct {
+	Id         string    `bson:"_id"`
+	Owner      string    `bson:"owner"`
+	Hostname   string    `bson:"hostname"`
+	AcquiredAt time.Time `bson:"acquired_at"`
+	ExpiresAt  time.Time `bson:"expires_at"`
 }
 
 type SSlOptions struct {
@@ -88,18 +142,66 @@ func WithMongoSsl(mongoSSlOptions SSlOptions) func(driver.Driver) {
 	}
 }
 
+// WithSession returns a Driver that runs migrations through session, an
+// existing session to a MongoDB server, and stores the applied migration
+// versions in database dbName. If dbName is empty, the DbName of the
+// registered methods receiver is used. The Driver doesn't reconnect and
+// Close doesn't close session, it's up to the caller to manage it.
+func WithSession(session *mgo.Session, dbName string) (*Driver, error) {
+	gen, exists := driver.GetDriverGenerator("mongodb")
+	if !exists {
+		return nil, UnregisteredMethodsReceiverError(DRIVER_NAME)
+	}
+	d, ok := gen.Generate().(*Driver)
+	if !ok {
+		return nil, WrongMethodsReceiverTypeError(DRIVER_NAME)
+	}
+	if d.methodsReceiver == nil {
+		return nil, UnregisteredMethodsReceiverError(DRIVER_NAME)
+	}
+	if err := session.Ping(); err != nil {
+		return nil, err
+	}
+	d.Session = session
+	d.dbName = dbName
+	d.lockKey = lockId
+	d.migrator = gomethods.Migrator{MethodInvoker: d}
+	return d, nil
+}
+
 func (d *Driver) Initialize(url string, initOptions ...func(driver.Driver)) error {
+	return d.InitializeContext(context.Background(), url, initOptions...)
+}
+
+// InitializeContext is like Initialize. The mgo driver doesn't support
+// cancellation, so ctx is only checked before dialing.
+func (d *Driver) InitializeContext(ctx context.Context, url string, initOptions ...func(driver.Driver)) error {
 	if d.methodsReceiver == nil {
 		return UnregisteredMethodsReceiverError(DRIVER_NAME)
 	}
+	url, options, err := driver.ParseOptions(url)
+	if err != nil {
+		return err
+	}
 	urlWithoutScheme := strings.SplitN(url, "mongodb://", 2)
 	if len(urlWithoutScheme) != 2 {
 		return errors.New("invalid mongodb:// scheme")
 	}
 	d.Url = url
+	d.migrationsC = options.MigrationsTable
+	d.lockKey = lockId
+	if options.LockKey != "" {
+		d.lockKey = options.LockKey
+	}
+	d.lockTimeout = options.LockTimeout
+	d.leaseTTL = options.LeaseTTL
 	for _, option := range initOptions {
 		option(d)
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d.ownsSession = true
 	if err := d.reconnectToMasterSession(); err != nil {
 		return fmt.Errorf("failed to connect to session: %v", err)
 	}
@@ -176,6 +278,10 @@ func readFileFromPath(path string) (string, error) {
 func (driver *Driver) ensureSessionNotClosed() (retErr error) {
 	defer func() {
 		if r := recover(); r != nil {
+			if !driver.ownsSession {
+				retErr = fmt.Errorf("session provided to WithSession is closed: %v", r)
+				return
+			}
 			if err := driver.reconnectToMasterSession(); err != nil {
 				retErr = fmt.Errorf("recovering from error: '%v'. failed to re-connect to master session: %v", r, err)
 				return
@@ -185,6 +291,9 @@ func (driver *Driver) ensureSessionNotClosed() (retErr error) {
 
 	//Ping panics if session is closed
 	if pingErr := driver.Session.Ping(); pingErr != nil {
+		if !driver.ownsSession {
+			return pingErr
+		}
 		if reconnectErr := driver.reconnectToMasterSession(); reconnectErr != nil {
 			retErr = fmt.Errorf("Session ping has failed: %v. Failed to re-connect to master session: %v", pingErr, reconnectErr)
 			return
@@ -202,17 +311,203 @@ func (driver *Driver) getNewSession() (*mgo.Session, error) {
 }
 
 func (driver *Driver) Close() error {
-	if driver.Session != nil {
+	if driver.Session != nil && driver.ownsSession {
 		driver.Session.Close()
 	}
 	return nil
 }
 
+// leaseLock returns the lock of the driver, a lease in the lockCollection.
+func (d *Driver) leaseLock() *driver.LeaseLock {
+	if d.lock == nil {
+		d.lock = driver.NewLeaseLock(leaseStore{d}, d.lockKey, d.leaseTTL)
+	}
+	return d.lock
+}
+
+func (d *Driver) Lock(ctx context.Context, timeout time.Duration) error {
+	if d.lockTimeout > 0 {
+		timeout = d.lockTimeout
+	}
+	return d.leaseLock().Lock(ctx, timeout)
+}
+
+func (d *Driver) Unlock() error {
+	return d.leaseLock().Unlock()
+}
+
+func (d *Driver) Lease(ctx context.Context) (*driver.Lease, error) {
+	return d.leaseLock().Lease(ctx)
+}
+
+func (d *Driver) BreakLease(ctx context.Context) error {
+	return d.leaseLock().BreakLease(ctx)
+}
+
+func (d *Driver) LeaseLost() <-chan struct{} {
+	return d.leaseLock().Lost()
+}
+
+// leaseStore stores leases as documents of the lockCollection, with the
+// lease key as id.
+type leaseStore struct {
+	d *Driver
+}
+
+// collection returns the lockCollection in a new session, which must be
+// closed.
+func (s leaseStore) collection() (*mgo.Session, *mgo.Collection, error) {
+	session, err := s.d.getNewSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get new session: %v", err)
+	}
+	return session, session.DB(s.d.databaseName()).C(s.d.lockCollection()), nil
+}
+
+// Acquire removes an expired lease first, then inserts lease, which
+// fails with a duplicate key error while someone else holds it.
+func (s leaseStore) Acquire(ctx context.Context, lease driver.Lease, now time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	session, c, err := s.collection()
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+
+	err = c.Remove(bson.M{"_id": lease.Key, "expires_at": bson.M{"$lte": now.UTC()}})
+	if err != nil && err != mgo.ErrNotFound {
+		return false, err
+	}
+	err = c.Insert(DbLock{
+		Id:         lease.Key,
+		Owner:      lease.Owner,
+		Hostname:   lease.Hostname,
+		AcquiredAt: lease.AcquiredAt.UTC(),
+		ExpiresAt:  lease.ExpiresAt.UTC(),
+	})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s leaseStore) Renew(ctx context.Context, lease driver.Lease) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	session, c, err := s.collection()
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+
+	err = c.Update(bson.M{"_id": lease.Key, "owner": lease.Owner}, bson.M{"$set": bson.M{"expires_at": lease.ExpiresAt.UTC()}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s leaseStore) Release(ctx context.Context, key, owner string) error {
+	session, c, err := s.collection()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := c.Remove(bson.M{"_id": key, "owner": owner}); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (s leaseStore) Get(ctx context.Context, key string) (*driver.Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	session, c, err := s.collection()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var lock DbLock
+	err = c.FindId(key).One(&lock)
+	switch {
+	case err == mgo.ErrNotFound:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return &driver.Lease{
+		Key:        lock.Id,
+		Owner:      lock.Owner,
+		Hostname:   lock.Hostname,
+		AcquiredAt: lock.AcquiredAt,
+		ExpiresAt:  lock.ExpiresAt,
+	}, nil
+}
+
+func (s leaseStore) Break(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	session, c, err := s.collection()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := c.RemoveId(key); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// migrationsCollection is the name of the collection that stores the
+// applied migration versions. The other collections are named after it.
+func (driver *Driver) migrationsCollection() string {
+	if driver.migrationsC != "" {
+		return driver.migrationsC
+	}
+	return MIGRATE_C
+}
+
+func (driver *Driver) historyCollection() string {
+	return driver.migrationsCollection() + "_history"
+}
+
+func (driver *Driver) dirtyCollection() string {
+	return driver.migrationsCollection() + "_dirty"
+}
+
+func (driver *Driver) lockCollection() string {
+	return driver.migrationsCollection() + "_lock"
+}
+
+// databaseName returns the name of the database that holds the
+// migrations collection.
+func (driver *Driver) databaseName() string {
+	if driver.dbName != "" {
+		return driver.dbName
+	}
+	return driver.methodsReceiver.DbName()
+}
+
 func (driver *Driver) FilenameExtension() string {
 	return "mgo"
 }
 
 func (driver *Driver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *Driver) VersionContext(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	var latestMigration DbMigration
 
 	session, err := driver.getNewSession()
@@ -220,7 +515,7 @@ func (driver *Driver) Version() (uint64, error) {
 		return 0, fmt.Errorf("failed to get new session: %v", err)
 	}
 	defer session.Close()
-	c := session.DB(driver.methodsReceiver.DbName()).C(MIGRATE_C)
+	c := session.DB(driver.databaseName()).C(driver.migrationsCollection())
 
 	err = c.Find(bson.M{}).Sort("-version").One(&latestMigration)
 	switch {
@@ -232,11 +527,259 @@ func (driver *Driver) Version() (uint64, error) {
 		return latestMigration.Version, nil
 	}
 }
+
+func (driver *Driver) AppliedVersions(ctx context.Context) ([]uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	session, err := driver.getNewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	c := session.DB(driver.databaseName()).C(driver.migrationsCollection())
+
+	var migrations []DbMigration
+	if err := c.Find(bson.M{}).Sort("version").All(&migrations); err != nil {
+		return nil, err
+	}
+	versions := make([]uint64, 0, len(migrations))
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	return versions, nil
+}
+
+func (driver *Driver) Checksums(ctx context.Context) (map[uint64]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	session, err := driver.getNewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	c := session.DB(driver.databaseName()).C(driver.migrationsCollection())
+
+	var migrations []DbMigration
+	if err := c.Find(bson.M{}).All(&migrations); err != nil {
+		return nil, err
+	}
+	checksums := make(map[uint64]string, len(migrations))
+	for _, m := range migrations {
+		checksums[m.Version] = m.Checksum
+	}
+	return checksums, nil
+}
+
+func (driver *Driver) SetChecksum(ctx context.Context, version uint64, checksum string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	session, err := driver.getNewSession()
+	if err != nil {
+		return fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	c := session.DB(driver.databaseName()).C(driver.migrationsCollection())
+
+	return c.Update(bson.M{"version": version}, bson.M{"$set": bson.M{"checksum": checksum}})
+}
+
+func (d *Driver) AddHistory(ctx context.Context, entry driver.HistoryEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	session, err := d.getNewSession()
+	if err != nil {
+		return fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	c := session.DB(d.databaseName()).C(d.historyCollection())
+
+	return c.Insert(DbHistoryEntry{
+		Id:          bson.NewObjectId(),
+		Version:     entry.Version,
+		Name:        entry.Name,
+		Direction:   entry.Direction.String(),
+		AppliedAt:   entry.AppliedAt,
+		DurationMs:  entry.Duration.Milliseconds(),
+		Hostname:    entry.Hostname,
+		ToolVersion: entry.ToolVersion,
+		Label:       entry.Label,
+	})
+}
+
+func (d *Driver) History(ctx context.Context) ([]driver.HistoryEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	session, err := d.getNewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	c := session.DB(d.databaseName()).C(d.historyCollection())
+
+	var entries []DbHistoryEntry
+	if err := c.Find(bson.M{}).Sort("applied_at", "_id").All(&entries); err != nil {
+		return nil, err
+	}
+	history := make([]driver.HistoryEntry, 0, len(entries))
+	for _, e := range entries {
+		dir, err := direction.Parse(e.Direction)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, driver.HistoryEntry{
+			Version:     e.Version,
+			Name:        e.Name,
+			Direction:   dir,
+			AppliedAt:   e.AppliedAt,
+			Duration:    time.Duration(e.DurationMs) * time.Millisecond,
+			Hostname:    e.Hostname,
+			ToolVersion: e.ToolVersion,
+			Label:       e.Label,
+		})
+	}
+	return history, nil
+}
+
+func (d *Driver) Baseline(ctx context.Context, files file.Files) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	session, err := d.getNewSession()
+	if err != nil {
+		return fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	migrate_c := session.DB(d.databaseName()).C(d.migrationsCollection())
+
+	n, err := migrate_c.Count()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return driver.ErrAlreadyMigrated
+	}
+	for _, f := range files {
+		checksum, err := f.Checksum()
+		if err != nil {
+			return err
+		}
+		if err := migrate_c.Insert(DbMigration{Id: bson.NewObjectId(), Version: f.Version, Checksum: checksum}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (driver *Driver) SetDirty(ctx context.Context, f file.File) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	session, err := driver.getNewSession()
+	if err != nil {
+		return fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	c := session.DB(driver.databaseName()).C(driver.dirtyCollection())
+
+	_, err = c.UpsertId(dirtyId, DbDirtyMigration{
+		Id:        dirtyId,
+		Version:   f.Version,
+		Name:      f.Name,
+		FileName:  f.FileName,
+		Direction: f.Direction.String(),
+	})
+	return err
+}
+
+func (driver *Driver) ClearDirty(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	session, err := driver.getNewSession()
+	if err != nil {
+		return fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	c := session.DB(driver.databaseName()).C(driver.dirtyCollection())
+
+	if err := c.RemoveId(dirtyId); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (driver *Driver) Dirty(ctx context.Context) (*file.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	session, err := driver.getNewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	c := session.DB(driver.databaseName()).C(driver.dirtyCollection())
+
+	var dirty DbDirtyMigration
+	err = c.FindId(dirtyId).One(&dirty)
+	switch {
+	case err == mgo.ErrNotFound:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	d, err := direction.Parse(dirty.Direction)
+	if err != nil {
+		return nil, err
+	}
+	return &file.File{Version: dirty.Version, Name: dirty.Name, FileName: dirty.FileName, Direction: d}, nil
+}
+
+// Force removes all versions above version and adds version, if it
+// hasn't been applied.
+func (driver *Driver) Force(ctx context.Context, version uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	session, err := driver.getNewSession()
+	if err != nil {
+		return fmt.Errorf("failed to get new session: %v", err)
+	}
+	defer session.Close()
+	migrate_c := session.DB(driver.databaseName()).C(driver.migrationsCollection())
+
+	if _, err := migrate_c.RemoveAll(bson.M{"version": bson.M{"$gt": version}}); err != nil {
+		return err
+	}
+	if version > 0 {
+		n, err := migrate_c.Find(bson.M{"version": version}).Count()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			if err := migrate_c.Insert(DbMigration{Id: bson.NewObjectId(), Version: version}); err != nil {
+				return err
+			}
+		}
+	}
+	return driver.ClearDirty(ctx)
+}
+
 func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+// MigrateContext is like Migrate, but stops invoking migration methods
+// once ctx is done. A method that is already running is not interrupted.
+func (driver *Driver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
 	defer close(pipe)
 	pipe <- f
 
-	err := driver.migrator.Migrate(f, pipe)
+	err := driver.migrator.MigrateContext(ctx, f, pipe)
 	if err != nil {
 		return
 	}
@@ -247,13 +790,18 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 		return
 	}
 	defer session.Close()
-	migrate_c := session.DB(driver.methodsReceiver.DbName()).C(MIGRATE_C)
+	migrate_c := session.DB(driver.databaseName()).C(driver.migrationsCollection())
 
 	if f.Direction == direction.Up {
+		checksum, err := f.Checksum()
+		if err != nil {
+			pipe <- err
+			return
+		}
 		id := bson.NewObjectId()
-		dbMigration := DbMigration{Id: id, Version: f.Version}
+		dbMigration := DbMigration{Id: id, Version: f.Version, Checksum: checksum}
 
-		err := migrate_c.Insert(dbMigration)
+		err = migrate_c.Insert(dbMigration)
 		if err != nil {
 			pipe <- err
 			return
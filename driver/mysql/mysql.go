@@ -4,12 +4,15 @@ package mysql
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/jfrog/go-dbmigrate/driver"
@@ -18,12 +21,86 @@ import (
 )
 
 type Driver struct {
-	db *sql.DB
+	db              *sql.DB
+	migrationsTable string
+	lockKey         string
+	lockTimeout     time.Duration
+	leaseStore      *driver.SQLLeaseStore
+	leaseLock       *driver.LeaseLock
+	ownsDB          bool
+	lockConn        *sql.Conn
 }
 
 const tableName = "schema_migrations"
 
+// Config holds the settings of a Driver created by WithInstance.
+type Config struct {
+	// MigrationsTable is the name of the table that stores the applied
+	// migration versions. Defaults to schema_migrations.
+	MigrationsTable string
+
+	// LockKey tells the named locks of applications sharing a database
+	// apart. Defaults to the migrations table.
+	LockKey string
+
+	// LockTimeout, if set, is how long to wait for the named lock,
+	// regardless of the timeout passed to Lock.
+	LockTimeout time.Duration
+
+	// LeaseLock makes the Driver use a lease in the <migrations table>_lock
+	// table instead of its named lock, e.g. behind a connection pooler.
+	LeaseLock bool
+
+	// LeaseTTL is how long the lease is valid unless it's renewed.
+	// Defaults to driver.DefaultLeaseTTL.
+	LeaseTTL time.Duration
+}
+
+// WithInstance returns a Driver that runs migrations on db, an existing
+// connection pool to a MySQL database. Close doesn't close db,
+// it's up to the caller to manage it. The lock takes a connection of the
+// pool while migrations run, so db must allow at least 2 open connections.
+func WithInstance(db *sql.DB, config *Config) (*Driver, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	if err := driver.CheckPoolSize(db); err != nil {
+		return nil, err
+	}
+	d := &Driver{
+		db:              db,
+		migrationsTable: config.MigrationsTable,
+		lockKey:         config.LockKey,
+		lockTimeout:     config.LockTimeout,
+	}
+	if d.migrationsTable == "" {
+		d.migrationsTable = tableName
+	}
+	if d.lockKey == "" {
+		d.lockKey = d.migrationsTable
+	}
+	if config.LeaseLock {
+		d.useLeaseLock(config.LeaseTTL)
+	}
+	ctx := context.Background()
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	if err := d.ensureVersionTableExists(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
 func (driver *Driver) Initialize(url string, initOptions ...func(driver.Driver)) error {
+	return driver.InitializeContext(context.Background(), url, initOptions...)
+}
+
+func (d *Driver) InitializeContext(ctx context.Context, url string, initOptions ...func(driver.Driver)) error {
+	url, options, err := driver.ParseOptions(url)
+	if err != nil {
+		return err
+	}
 	urlWithoutScheme := strings.SplitN(url, "mysql://", 2)
 	if len(urlWithoutScheme) != 2 {
 		return errors.New("invalid mysql:// scheme")
@@ -33,52 +110,223 @@ func (driver *Driver) Initialize(url string, initOptions ...func(driver.Driver))
 	if err != nil {
 		return err
 	}
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
 		return err
 	}
-	driver.db = db
+	d.db = db
+	d.ownsDB = true
+	d.migrationsTable = tableName
+	if options.MigrationsTable != "" {
+		d.migrationsTable = options.MigrationsTable
+	}
+	d.lockKey = d.migrationsTable
+	if options.LockKey != "" {
+		d.lockKey = options.LockKey
+	}
+	d.lockTimeout = options.LockTimeout
+	if options.LeaseLock {
+		d.useLeaseLock(options.LeaseTTL)
+	}
 
-	if err := driver.ensureVersionTableExists(); err != nil {
+	if err := d.ensureVersionTableExists(ctx); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (driver *Driver) Close() error {
+	// closing the lock connection releases the named lock
+	if driver.lockConn != nil {
+		driver.lockConn.Close()
+		driver.lockConn = nil
+	}
+	if !driver.ownsDB {
+		return nil
+	}
 	if err := driver.db.Close(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (driver *Driver) ensureVersionTableExists() error {
-	_, err := driver.db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + " (version int not null primary key);")
+// lockName is the name of the lock taken by Lock, unique per database
+// and lock key.
+const lockName = "CONCAT('migrate:', DATABASE(), '.', ?)"
+
+// Lock acquires a named lock with GET_LOCK. Named locks belong to a
+// session, so the lock is taken on a connection that is kept aside until
+// Unlock. https://dev.mysql.com/doc/refman/5.7/en/locking-functions.html
+func (d *Driver) Lock(ctx context.Context, timeout time.Duration) error {
+	if d.lockTimeout > 0 {
+		timeout = d.lockTimeout
+	}
+	if d.leaseLock != nil {
+		return d.leaseLock.Lock(ctx, timeout)
+	}
+
+	if d.lockConn != nil {
+		return driver.ErrLocked
+	}
+
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a negative timeout waits forever, or until ctx is done
+	seconds := -1
+	if timeout > 0 {
+		seconds = int(math.Ceil(timeout.Seconds()))
+	}
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK("+lockName+", ?)", d.lockKey, seconds).Scan(&acquired); err != nil {
+		conn.Close()
+		return fmt.Errorf("MySQL try lock failed: %v", err)
+	}
+	if acquired.Int64 != 1 {
+		conn.Close()
+		return driver.ErrLocked
+	}
+
+	d.lockConn = conn
+	return nil
+}
+
+func (d *Driver) Unlock() error {
+	if d.leaseLock != nil {
+		return d.leaseLock.Unlock()
+	}
+	if d.lockConn == nil {
+		return nil
+	}
+	defer func() {
+		d.lockConn.Close()
+		d.lockConn = nil
+	}()
+
+	if _, err := d.lockConn.ExecContext(context.Background(), "DO RELEASE_LOCK("+lockName+")", d.lockKey); err != nil {
+		return fmt.Errorf("MySQL try unlock failed: %v", err)
+	}
+	return nil
+}
+
+// useLeaseLock makes the driver use a lease in the <migrations table>_lock
+// table instead of its named lock.
+func (d *Driver) useLeaseLock(ttl time.Duration) {
+	d.leaseStore = &driver.SQLLeaseStore{DB: d.db, Table: d.migrationsTable + "_lock", DollarPlaceholders: false}
+	d.leaseLock = driver.NewLeaseLock(d.leaseStore, d.lockKey, ttl)
+}
+
+func (d *Driver) Lease(ctx context.Context) (*driver.Lease, error) {
+	if d.leaseLock == nil {
+		return nil, driver.ErrNoLease
+	}
+	return d.leaseLock.Lease(ctx)
+}
+
+func (d *Driver) BreakLease(ctx context.Context) error {
+	if d.leaseLock == nil {
+		return driver.ErrNoLease
+	}
+	return d.leaseLock.BreakLease(ctx)
+}
+
+func (d *Driver) LeaseLost() <-chan struct{} {
+	if d.leaseLock == nil {
+		return nil
+	}
+	return d.leaseLock.Lost()
+}
+
+func (driver *Driver) ensureVersionTableExists(ctx context.Context) error {
+	_, err := driver.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+driver.migrationsTable+" (version bigint not null primary key);")
 
+	if _, isWarn := err.(mysql.MySQLWarnings); err != nil && !isWarn {
+		return err
+	}
+	if err := driver.ensureChecksumColumnExists(ctx); err != nil {
+		return err
+	}
+	if err := driver.ensureBigintVersion(ctx); err != nil {
+		return err
+	}
+
+	_, err = driver.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+driver.historyTable()+" (id int not null auto_increment primary key, version bigint not null, name varchar(255) not null, direction varchar(4) not null, applied_at datetime(6) not null, duration_ms bigint not null, hostname varchar(255) not null, tool_version varchar(64) not null, label varchar(255) not null);")
+	if _, isWarn := err.(mysql.MySQLWarnings); err != nil && !isWarn {
+		return err
+	}
+
+	_, err = driver.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+driver.dirtyTable()+" (version bigint not null, name varchar(255) not null, file_name varchar(255) not null, direction varchar(4) not null);")
 	if _, isWarn := err.(mysql.MySQLWarnings); err != nil && !isWarn {
 		return err
 	}
 
+	if driver.leaseStore != nil {
+		err = driver.leaseStore.CreateTable(ctx)
+		if _, isWarn := err.(mysql.MySQLWarnings); err != nil && !isWarn {
+			return err
+		}
+	}
 	return nil
 }
 
+// ensureChecksumColumnExists adds the checksum column to tables
+// created before checksums were stored.
+func (driver *Driver) ensureChecksumColumnExists(ctx context.Context) error {
+	var count int
+	err := driver.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = 'checksum'", driver.migrationsTable).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = driver.db.ExecContext(ctx, "ALTER TABLE "+driver.migrationsTable+" ADD COLUMN checksum varchar(64) not null default ''")
+	return err
+}
+
+// ensureBigintVersion widens the version column of tables created with
+// an int column, which can't hold timestamp versions.
+func (driver *Driver) ensureBigintVersion(ctx context.Context) error {
+	var dataType string
+	err := driver.db.QueryRowContext(ctx, "SELECT data_type FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = 'version'", driver.migrationsTable).Scan(&dataType)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil || dataType != "int" {
+		return err
+	}
+	_, err = driver.db.ExecContext(ctx, "ALTER TABLE "+driver.migrationsTable+" MODIFY version bigint not null")
+	return err
+}
+
 func (driver *Driver) FilenameExtension() string {
 	return "sql"
 }
 
 func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+func (driver *Driver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
 	defer close(pipe)
 	pipe <- f
 
 	// http://go-database-sql.org/modifying.html, Working with Transactions
 	// You should not mingle the use of transaction-related functions such as Begin() and Commit() with SQL statements such as BEGIN and COMMIT in your SQL code.
-	tx, err := driver.db.Begin()
+	tx, err := driver.db.BeginTx(ctx, nil)
 	if err != nil {
 		pipe <- err
 		return
 	}
 
 	if f.Direction == direction.Up {
-		if _, err := tx.Exec("INSERT INTO "+tableName+" (version) VALUES (?)", f.Version); err != nil {
+		checksum, err := f.Checksum()
+		if err != nil {
+			pipe <- err
+			if err := tx.Rollback(); err != nil {
+				pipe <- err
+			}
+			return
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+driver.migrationsTable+" (version, checksum) VALUES (?, ?)", f.Version, checksum); err != nil {
 			pipe <- err
 			if err := tx.Rollback(); err != nil {
 				pipe <- err
@@ -86,7 +334,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 			return
 		}
 	} else if f.Direction == direction.Down {
-		if _, err := tx.Exec("DELETE FROM "+tableName+" WHERE version = ?", f.Version); err != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+driver.migrationsTable+" WHERE version = ?", f.Version); err != nil {
 			pipe <- err
 			if err := tx.Rollback(); err != nil {
 				pipe <- err
@@ -97,6 +345,9 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 
 	if err := f.ReadContent(); err != nil {
 		pipe <- err
+		if err := tx.Rollback(); err != nil {
+			pipe <- err
+		}
 		return
 	}
 
@@ -107,7 +358,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 	for _, sqlStmt := range sqlStmts {
 		sqlStmt = bytes.TrimSpace(sqlStmt)
 		if len(sqlStmt) > 0 {
-			if _, err := tx.Exec(string(sqlStmt)); err != nil {
+			if _, err := tx.ExecContext(ctx, string(sqlStmt)); err != nil {
 				mysqlErr, isErr := err.(*mysql.MySQLError)
 
 				if isErr {
@@ -155,6 +406,14 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 						pipe <- err
 					}
 
+					return
+				} else {
+					// e.g. context.Canceled or a broken connection, the
+					// transaction is gone
+					pipe <- err
+					if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+						pipe <- err
+					}
 					return
 				}
 			}
@@ -168,8 +427,12 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 }
 
 func (driver *Driver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *Driver) VersionContext(ctx context.Context) (uint64, error) {
 	var version uint64
-	err := driver.db.QueryRow("SELECT version FROM " + tableName + " ORDER BY version DESC").Scan(&version)
+	err := driver.db.QueryRowContext(ctx, "SELECT version FROM "+driver.migrationsTable+" ORDER BY version DESC").Scan(&version)
 	switch {
 	case err == sql.ErrNoRows:
 		return 0, nil
@@ -180,8 +443,180 @@ func (driver *Driver) Version() (uint64, error) {
 	}
 }
 
+func (driver *Driver) AppliedVersions(ctx context.Context) ([]uint64, error) {
+	rows, err := driver.db.QueryContext(ctx, "SELECT version FROM "+driver.migrationsTable+" ORDER BY version ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := make([]uint64, 0)
+	for rows.Next() {
+		var version uint64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (driver *Driver) Checksums(ctx context.Context) (map[uint64]string, error) {
+	rows, err := driver.db.QueryContext(ctx, "SELECT version, checksum FROM "+driver.migrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	checksums := make(map[uint64]string)
+	for rows.Next() {
+		var version uint64
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[version] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+func (driver *Driver) SetChecksum(ctx context.Context, version uint64, checksum string) error {
+	_, err := driver.db.ExecContext(ctx, "UPDATE "+driver.migrationsTable+" SET checksum = ? WHERE version = ?", checksum, version)
+	return err
+}
+
+func (driver *Driver) historyTable() string {
+	return driver.migrationsTable + "_history"
+}
+
+func (d *Driver) AddHistory(ctx context.Context, entry driver.HistoryEntry) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO "+d.historyTable()+" (version, name, direction, applied_at, duration_ms, hostname, tool_version, label) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.Version, entry.Name, entry.Direction.String(), entry.AppliedAt, entry.Duration.Milliseconds(), entry.Hostname, entry.ToolVersion, entry.Label)
+	return err
+}
+
+func (d *Driver) History(ctx context.Context) ([]driver.HistoryEntry, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT version, name, direction, applied_at, duration_ms, hostname, tool_version, label FROM "+d.historyTable()+" ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := make([]driver.HistoryEntry, 0)
+	for rows.Next() {
+		var entry driver.HistoryEntry
+		var dir string
+		var appliedAt mysql.NullTime
+		var durationMs int64
+		if err := rows.Scan(&entry.Version, &entry.Name, &dir, &appliedAt, &durationMs, &entry.Hostname, &entry.ToolVersion, &entry.Label); err != nil {
+			return nil, err
+		}
+		if entry.Direction, err = direction.Parse(dir); err != nil {
+			return nil, err
+		}
+		entry.AppliedAt = appliedAt.Time
+		entry.Duration = time.Duration(durationMs) * time.Millisecond
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+func (d *Driver) Baseline(ctx context.Context, files file.Files) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+d.migrationsTable).Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return driver.ErrAlreadyMigrated
+	}
+	for _, f := range files {
+		checksum, err := f.Checksum()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+d.migrationsTable+" (version, checksum) VALUES (?, ?)", f.Version, checksum); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (driver *Driver) dirtyTable() string {
+	return driver.migrationsTable + "_dirty"
+}
+
+func (driver *Driver) SetDirty(ctx context.Context, f file.File) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+driver.dirtyTable()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO "+driver.dirtyTable()+" (version, name, file_name, direction) VALUES (?, ?, ?, ?)", f.Version, f.Name, f.FileName, f.Direction.String()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (driver *Driver) ClearDirty(ctx context.Context) error {
+	_, err := driver.db.ExecContext(ctx, "DELETE FROM "+driver.dirtyTable())
+	return err
+}
+
+func (driver *Driver) Dirty(ctx context.Context) (*file.File, error) {
+	f := &file.File{}
+	var d string
+	err := driver.db.QueryRowContext(ctx, "SELECT version, name, file_name, direction FROM "+driver.dirtyTable()).Scan(&f.Version, &f.Name, &f.FileName, &d)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	if f.Direction, err = direction.Parse(d); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Force removes all versions above version and adds version, if it
+// hasn't been applied.
+func (driver *Driver) Force(ctx context.Context, version uint64) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+driver.migrationsTable+" WHERE version > ?", version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if version > 0 {
+		if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO "+driver.migrationsTable+" (version) VALUES (?)", version); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+driver.dirtyTable()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
 func init() {
 	driver.RegisterDriver("mysql", driver.NewDriverGenerator(
-		func() driver.Driver { return &Driver{} }))
+		func() driver.Driver { return &Driver{} }).WithInfo(driver.Info{
+		FilenameExtension: "sql",
+		Description:       "MySQL",
+		URLFormat:         "mysql://user@tcp(host:port)/database",
+	}))
 
 }
@@ -101,3 +101,49 @@ func TestMigrate(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestTimestampVersion(t *testing.T) {
+	host := os.Getenv("MYSQL_PORT_3306_TCP_ADDR")
+	port := os.Getenv("MYSQL_PORT_3306_TCP_PORT")
+	driverUrl := "mysql://root@tcp(" + host + ":" + port + ")/migratetest"
+
+	connection, err := sql.Open("mysql", strings.SplitN(driverUrl, "mysql://", 2)[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`DROP TABLE IF EXISTS yolo, ` + tableName); err != nil {
+		t.Fatal(err)
+	}
+	// a table created before timestamp versions, with an int version column
+	if _, err := connection.Exec("CREATE TABLE " + tableName + " (version int not null primary key)"); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	pipe := pipep.New()
+	go d.Migrate(file.File{
+		Path:      "/foobar",
+		FileName:  "20240131154500_foobar.up.sql",
+		Version:   20240131154500,
+		Name:      "foobar",
+		Direction: direction.Up,
+		Content:   []byte("CREATE TABLE yolo (id int(11) not null primary key auto_increment);"),
+	}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	version, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 20240131154500 {
+		t.Fatalf("Expected version 20240131154500, got %v", version)
+	}
+}
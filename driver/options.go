@@ -0,0 +1,119 @@
+package driver
+
+import (
+	"fmt"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Options are the settings that all drivers read from the query of their
+// url. See ParseOptions.
+type Options struct {
+	// MigrationsTable is the name of the table (or collection) that stores
+	// the applied migration versions, set with x-migrations-table.
+	// Empty means the default of the driver.
+	MigrationsTable string
+
+	// LockKey tells the migration locks of applications sharing a database
+	// apart, set with x-lock-key. Empty means the default of the driver.
+	LockKey string
+
+	// LockTimeout is how long to wait for the migration lock, set with
+	// x-lock-timeout as a duration such as 30s. If it's set, it takes
+	// precedence over the timeout passed to Locker.Lock.
+	LockTimeout time.Duration
+
+	// LeaseLock makes drivers with a native lock use a LeaseLock instead,
+	// set with x-lock=lease. x-lock=native is the default.
+	LeaseLock bool
+
+	// LeaseTTL is how long a lease is valid unless it's renewed, set with
+	// x-lease-ttl. 0 means DefaultLeaseTTL.
+	LeaseTTL time.Duration
+}
+
+// tableNameRegex matches the table names that can be used in queries
+// without quoting, optionally qualified by a schema or keyspace.
+var tableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// lockKeyRegex matches the lock keys that are safe to use in queries and
+// filenames, e.g. of the lock file of sqlite3. Dots may only separate
+// words, so a key can't refer to a parent directory.
+var lockKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+// ParseOptions returns rawurl without the x- options of its query, along
+// with the options. The rest of rawurl is left as it is, so it doesn't
+// have to be a valid URL, e.g. a MySQL DSN. Unknown x- options are an error.
+func ParseOptions(rawurl string) (string, Options, error) {
+	var options Options
+	i := strings.Index(rawurl, "?")
+	if i < 0 {
+		return rawurl, options, nil
+	}
+
+	params := make([]string, 0)
+	for _, param := range strings.Split(rawurl[i+1:], "&") {
+		if !strings.HasPrefix(param, "x-") {
+			params = append(params, param)
+			continue
+		}
+		key, value := param, ""
+		if j := strings.Index(param, "="); j >= 0 {
+			key, value = param[:j], param[j+1:]
+		}
+		value, err := neturl.QueryUnescape(value)
+		if err != nil {
+			return "", options, fmt.Errorf("Invalid %s option: %v", key, err)
+		}
+
+		switch key {
+		case "x-migrations-table":
+			if !tableNameRegex.MatchString(value) {
+				return "", options, fmt.Errorf("Invalid %s option: %q is not a valid table name", key, value)
+			}
+			options.MigrationsTable = value
+		case "x-lock-key":
+			if !lockKeyRegex.MatchString(value) {
+				return "", options, fmt.Errorf("Invalid %s option: %q may only contain letters, digits, '_', '-' and '.'", key, value)
+			}
+			options.LockKey = value
+		case "x-lock-timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil {
+				return "", options, fmt.Errorf("Invalid %s option: %v", key, err)
+			}
+			if timeout < 0 {
+				return "", options, fmt.Errorf("Invalid %s option: %v is negative", key, timeout)
+			}
+			options.LockTimeout = timeout
+		case "x-lock":
+			switch value {
+			case "native":
+				options.LeaseLock = false
+			case "lease":
+				options.LeaseLock = true
+			default:
+				return "", options, fmt.Errorf("Invalid %s option: %q is neither native nor lease", key, value)
+			}
+		case "x-lease-ttl":
+			ttl, err := time.ParseDuration(value)
+			if err != nil {
+				return "", options, fmt.Errorf("Invalid %s option: %v", key, err)
+			}
+			if ttl < time.Second {
+				return "", options, fmt.Errorf("Invalid %s option: %v is less than a second", key, ttl)
+			}
+			options.LeaseTTL = ttl
+		default:
+			return "", options, fmt.Errorf("Unknown option %s", key)
+		}
+	}
+
+	rawurl = rawurl[:i]
+	if len(params) > 0 {
+		rawurl += "?" + strings.Join(params, "&")
+	}
+	return rawurl, options, nil
+}
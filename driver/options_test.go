@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+		options  Options
+		err      bool
+	}{
+		{url: "postgres://localhost/db", expected: "postgres://localhost/db"},
+		{
+			url:      "postgres://localhost/db?sslmode=disable&x-migrations-table=app_migrations&x-lock-key=app&x-lock-timeout=30s",
+			expected: "postgres://localhost/db?sslmode=disable",
+			options:  Options{MigrationsTable: "app_migrations", LockKey: "app", LockTimeout: 30 * time.Second},
+		},
+		{
+			url:      "mysql://user@tcp(127.0.0.1:3306)/db?x-migrations-table=app.migrations&parseTime=true",
+			expected: "mysql://user@tcp(127.0.0.1:3306)/db?parseTime=true",
+			options:  Options{MigrationsTable: "app.migrations"},
+		},
+		{
+			url:      "postgres://localhost/db?x-lock=lease&x-lease-ttl=1m",
+			expected: "postgres://localhost/db",
+			options:  Options{LeaseLock: true, LeaseTTL: time.Minute},
+		},
+		{url: "sqlite3:///tmp/db?x-lock-key=app-1.blue_v2", expected: "sqlite3:///tmp/db", options: Options{LockKey: "app-1.blue_v2"}},
+		{url: "sqlite3:///tmp/db?x-lock-key=a%20b", err: true},
+		{url: "sqlite3:///tmp/db?x-lock-key=../../etc/passwd", err: true},
+		{url: "sqlite3:///tmp/db?x-lock-key=a/b", err: true},
+		{url: "sqlite3:///tmp/db?x-lock-key=..", err: true},
+		{url: "sqlite3:///tmp/db?x-lock-key=", err: true},
+		{url: "postgres://localhost/db?x-migrations-table=users;drop", err: true},
+		{url: "postgres://localhost/db?x-lock-timeout=soon", err: true},
+		{url: "postgres://localhost/db?x-lock-timeout=-1s", err: true},
+		{url: "postgres://localhost/db?x-lock=file", err: true},
+		{url: "postgres://localhost/db?x-lease-ttl=10ms", err: true},
+		{url: "postgres://localhost/db?x-unknown=1", err: true},
+	}
+
+	for _, test := range tests {
+		url, options, err := ParseOptions(test.url)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error for %v", test.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", test.url, err)
+			continue
+		}
+		if url != test.expected {
+			t.Errorf("Expected url %v for %v, got %v", test.expected, test.url, url)
+		}
+		if options != test.options {
+			t.Errorf("Expected options %+v for %v, got %+v", test.options, test.url, options)
+		}
+	}
+}
@@ -9,42 +9,138 @@ import (
 	"github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/jfrog/go-dbmigrate/driver"
+	"github.com/jfrog/go-dbmigrate/driver/internal/pgtables"
 	"github.com/jfrog/go-dbmigrate/file"
 	"github.com/jfrog/go-dbmigrate/migrate/direction"
+	"time"
 )
 
 type Driver struct {
-	db       *sql.DB
-	url      string
-	isLocked bool
+	*pgtables.Tables
+	db              *sql.DB
+	url             string
+	lockConn        *sql.Conn
+	migrationsTable string
+	lockKey         string
+	lockTimeout     time.Duration
+	leaseStore      *driver.SQLLeaseStore
+	leaseLock       *driver.LeaseLock
+	ownsDB          bool
 }
 
 const tableName = "schema_migrations"
 const driverName = "pgx"
 
+// Config holds the settings of a Driver created by WithInstance.
+type Config struct {
+	// MigrationsTable is the name of the table that stores the applied
+	// migration versions. Defaults to schema_migrations.
+	MigrationsTable string
+
+	// LockKey tells the advisory locks of applications sharing a
+	// database apart. Defaults to xraydb.
+	LockKey string
+
+	// LockTimeout, if set, is how long to wait for the advisory lock,
+	// regardless of the timeout passed to Lock.
+	LockTimeout time.Duration
+
+	// LeaseLock makes the Driver use a lease in the <migrations table>_lock
+	// table instead of its advisory lock, e.g. behind a connection pooler.
+	LeaseLock bool
+
+	// LeaseTTL is how long the lease is valid unless it's renewed.
+	// Defaults to driver.DefaultLeaseTTL.
+	LeaseTTL time.Duration
+}
+
+// defaultLockKey is the default key of the advisory lock.
+const defaultLockKey = "xraydb"
+
+// WithInstance returns a Driver that runs migrations on db, an existing
+// connection pool to a PostgreSQL database. The Driver doesn't reconnect
+// and Close doesn't close db, it's up to the caller to manage it. The lock
+// takes a connection of the pool while migrations run, so db must allow
+// at least 2 open connections.
+func WithInstance(db *sql.DB, config *Config) (*Driver, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	if err := driver.CheckPoolSize(db); err != nil {
+		return nil, err
+	}
+	d := &Driver{
+		db:              db,
+		migrationsTable: config.MigrationsTable,
+		lockKey:         config.LockKey,
+		lockTimeout:     config.LockTimeout,
+	}
+	if d.migrationsTable == "" {
+		d.migrationsTable = tableName
+	}
+	d.Tables = pgtables.New(d.conn, d.migrationsTable)
+	if d.lockKey == "" {
+		d.lockKey = defaultLockKey
+	}
+	if config.LeaseLock {
+		d.useLeaseLock(config.LeaseTTL)
+	}
+	ctx := context.Background()
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	if err := d.ensureVersionTableExists(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
 func (driver *Driver) Initialize(url string, initOptions ...func(driver.Driver)) error {
+	return driver.InitializeContext(context.Background(), url, initOptions...)
+}
+
+func (d *Driver) InitializeContext(ctx context.Context, url string, initOptions ...func(driver.Driver)) error {
+	url, options, err := driver.ParseOptions(url)
+	if err != nil {
+		return err
+	}
+
 	db, err := sql.Open(driverName, url)
 	if err != nil {
 		return err
 	}
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
 		return err
 	}
-	driver.db = db
-	driver.url = url
+	d.db = db
+	d.url = url
+	d.ownsDB = true
+	d.migrationsTable = tableName
+	if options.MigrationsTable != "" {
+		d.migrationsTable = options.MigrationsTable
+	}
+	d.Tables = pgtables.New(d.conn, d.migrationsTable)
+	d.lockKey = defaultLockKey
+	if options.LockKey != "" {
+		d.lockKey = options.LockKey
+	}
+	d.lockTimeout = options.LockTimeout
+	if options.LeaseLock {
+		d.useLeaseLock(options.LeaseTTL)
+	}
 
-	if err := driver.ensureVersionTableExists(); err != nil {
+	if err := d.ensureVersionTableExists(ctx); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (driver *Driver) ensureConnectionNotClosed() error {
-	pingErr := driver.db.Ping()
+func (driver *Driver) ensureConnectionNotClosed(ctx context.Context) error {
+	pingErr := driver.db.PingContext(ctx)
 	if pingErr == nil {
 		return nil
 	}
-	if pingErr.Error() != "sql: database is closed" {
+	if pingErr.Error() != "sql: database is closed" || !driver.ownsDB {
 		return pingErr
 	}
 
@@ -52,64 +148,147 @@ func (driver *Driver) ensureConnectionNotClosed() error {
 	if err != nil {
 		return err
 	}
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
 		return err
 	}
 	driver.db = db
 	return nil
 }
 
+// conn returns the connection pool, reconnecting if it has been closed.
+func (d *Driver) conn(ctx context.Context) (*sql.DB, error) {
+	if err := d.ensureConnectionNotClosed(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ensure db connection is open: %v", err)
+	}
+	return d.db, nil
+}
+
 func (p *Driver) Close() error {
+	// closing the lock connection releases the advisory lock
+	if p.lockConn != nil {
+		p.lockConn.Close()
+		p.lockConn = nil
+	}
+	if !p.ownsDB {
+		return nil
+	}
 	if err := p.db.Close(); !driver.CanIgnoreError(err) {
 		return err
 	}
 	return nil
 }
 
+// Lock acquires an advisory lock, polling pg_try_advisory_lock until
+// timeout. Advisory locks belong to a session, so the lock is taken on
+// a connection that is kept aside until Unlock.
 // https://www.postgresql.org/docs/9.6/static/explicit-locking.html#ADVISORY-LOCKS
-func (p *Driver) Lock() error {
-	if p.isLocked {
+func (p *Driver) Lock(ctx context.Context, timeout time.Duration) error {
+	if p.lockTimeout > 0 {
+		timeout = p.lockTimeout
+	}
+	if p.leaseLock != nil {
+		return p.leaseLock.Lock(ctx, timeout)
+	}
+
+	if p.lockConn != nil {
 		return driver.ErrLocked
 	}
 
-	aid, err := driver.GenerateAdvisoryLockId("xraydb", "migrate-postgres")
+	aid, err := driver.GenerateAdvisoryLockId(p.lockKey, "migrate-postgres")
+	if err != nil {
+		return err
+	}
+
+	conn, err := p.db.Conn(ctx)
 	if err != nil {
 		return err
 	}
 
-	// This will wait indefinitely until the lock can be acquired.
-	query := `SELECT pg_advisory_lock($1)`
-	if _, err := p.db.ExecContext(context.Background(), query, aid); err != nil {
+	err = driver.PollLock(ctx, timeout, func(ctx context.Context) (acquired bool, err error) {
+		err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, aid).Scan(&acquired)
+		return acquired, err
+	})
+	if err != nil {
+		conn.Close()
+		if err == driver.ErrLocked || ctx.Err() != nil {
+			return err
+		}
 		return fmt.Errorf("Postgres try lock failed: %v", err)
 	}
 
-	p.isLocked = true
+	p.lockConn = conn
 	return nil
 }
 
 func (p *Driver) Unlock() error {
-	if !p.isLocked {
+	if p.leaseLock != nil {
+		return p.leaseLock.Unlock()
+	}
+	if p.lockConn == nil {
 		return nil
 	}
+	defer func() {
+		p.lockConn.Close()
+		p.lockConn = nil
+	}()
 
-	aid, err := driver.GenerateAdvisoryLockId("xraydb", "migrate-postgres")
+	aid, err := driver.GenerateAdvisoryLockId(p.lockKey, "migrate-postgres")
 	if err != nil {
 		return err
 	}
 
 	query := `SELECT pg_advisory_unlock($1)`
-	if _, err := p.db.ExecContext(context.Background(), query, aid); err != nil {
+	if _, err := p.lockConn.ExecContext(context.Background(), query, aid); err != nil {
 		return fmt.Errorf("Postgres try unlock failed: %v", err)
 	}
-	p.isLocked = false
 	return nil
 }
 
-func (driver *Driver) ensureVersionTableExists() (err error) {
-	if err := driver.Lock(); err != nil {
-		return err
+// useLeaseLock makes the driver use a lease in the <migrations table>_lock
+// table instead of its advisory lock.
+func (d *Driver) useLeaseLock(ttl time.Duration) {
+	d.leaseStore = &driver.SQLLeaseStore{DB: d.db, Table: d.migrationsTable + "_lock", DollarPlaceholders: true}
+	d.leaseLock = driver.NewLeaseLock(d.leaseStore, d.lockKey, ttl)
+}
+
+func (d *Driver) Lease(ctx context.Context) (*driver.Lease, error) {
+	if d.leaseLock == nil {
+		return nil, driver.ErrNoLease
+	}
+	return d.leaseLock.Lease(ctx)
+}
+
+func (d *Driver) BreakLease(ctx context.Context) error {
+	if d.leaseLock == nil {
+		return driver.ErrNoLease
+	}
+	return d.leaseLock.BreakLease(ctx)
+}
+
+func (d *Driver) LeaseLost() <-chan struct{} {
+	if d.leaseLock == nil {
+		return nil
 	}
+	return d.leaseLock.Lost()
+}
 
+func (driver *Driver) ensureVersionTableExists(ctx context.Context) (err error) {
+	if driver.leaseStore != nil {
+		if err := driver.leaseStore.CreateTable(ctx); err != nil {
+			return err
+		}
+		// a lease can be held by a hung migration, which must not keep
+		// anyone from connecting to inspect or break it, so it's only
+		// waited for if the tables have to be created or upgraded
+		upToDate, err := driver.Tables.UpToDate(ctx)
+		if err != nil || upToDate {
+			return err
+		}
+	}
+
+	if err := driver.Lock(ctx, 0); err != nil {
+		return err
+	}
 	defer func() {
 		if e := driver.Unlock(); e != nil {
 			if err == nil {
@@ -120,10 +299,7 @@ func (driver *Driver) ensureVersionTableExists() (err error) {
 		}
 	}()
 
-	if _, err := driver.db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + " (version int not null primary key);"); err != nil {
-		return err
-	}
-	return nil
+	return driver.Tables.Ensure(ctx)
 }
 
 func (driver *Driver) FilenameExtension() string {
@@ -131,21 +307,33 @@ func (driver *Driver) FilenameExtension() string {
 }
 
 func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+func (driver *Driver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
 	defer close(pipe)
 	pipe <- f
 
-	if err := driver.ensureConnectionNotClosed(); err != nil {
+	if err := driver.ensureConnectionNotClosed(ctx); err != nil {
 		pipe <- fmt.Errorf("failed to ensure db connection is open: %v", err)
 		return
 	}
-	tx, err := driver.db.Begin()
+	tx, err := driver.db.BeginTx(ctx, nil)
 	if err != nil {
 		pipe <- err
 		return
 	}
 
 	if f.Direction == direction.Up {
-		if _, err := tx.Exec("INSERT INTO "+tableName+" (version) VALUES ($1)", f.Version); err != nil {
+		checksum, err := f.Checksum()
+		if err != nil {
+			pipe <- err
+			if err := tx.Rollback(); err != nil {
+				pipe <- err
+			}
+			return
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+driver.migrationsTable+" (version, checksum) VALUES ($1, $2)", f.Version, checksum); err != nil {
 			pipe <- err
 			if err := tx.Rollback(); err != nil {
 				pipe <- err
@@ -153,7 +341,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 			return
 		}
 	} else if f.Direction == direction.Down {
-		if _, err := tx.Exec("DELETE FROM "+tableName+" WHERE version=$1", f.Version); err != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+driver.migrationsTable+" WHERE version=$1", f.Version); err != nil {
 			pipe <- err
 			if err := tx.Rollback(); err != nil {
 				pipe <- err
@@ -167,7 +355,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 		return
 	}
 
-	if _, err := tx.Exec(string(f.Content)); err != nil {
+	if _, err := tx.ExecContext(ctx, string(f.Content)); err != nil {
 		pgError, ok := err.(*pgconn.PgError)
 		if ok {
 			position := int(pgError.Position)
@@ -194,24 +382,11 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 	}
 }
 
-func (driver *Driver) Version() (uint64, error) {
-	if err := driver.ensureConnectionNotClosed(); err != nil {
-		return 0, fmt.Errorf("failed to ensure db connection is open: %v", err)
-	}
-
-	var version uint64
-	err := driver.db.QueryRow("SELECT version FROM " + tableName + " ORDER BY version DESC LIMIT 1").Scan(&version)
-	switch {
-	case err == sql.ErrNoRows:
-		return 0, nil
-	case err != nil:
-		return 0, err
-	default:
-		return version, nil
-	}
-}
-
 func init() {
 	driver.RegisterDriver("postgres", driver.NewDriverGenerator(
-		func() driver.Driver { return &Driver{} }))
+		func() driver.Driver { return &Driver{} }).WithInfo(driver.Info{
+		FilenameExtension: "sql",
+		Description:       "PostgreSQL",
+		URLFormat:         "postgres://user@host:port/database",
+	}))
 }
@@ -1,6 +1,8 @@
 package driver
 
 import (
+	"fmt"
+	neturl "net/url"
 	"sort"
 	"sync"
 )
@@ -31,6 +33,37 @@ func GetDriverGenerator(name string) (*DriverGenerator, bool) {
 	return driver, ok
 }
 
+// GetDriverInfo returns the static metadata of a registered driver.
+// Drivers that have been registered without it get their filename
+// extension from a driver that isn't initialized.
+func GetDriverInfo(name string) (Info, bool) {
+	gen, ok := GetDriverGenerator(name)
+	if !ok {
+		return Info{}, false
+	}
+	info := gen.info
+	if info.FilenameExtension == "" {
+		d := gen.Generate()
+		verifyFilenameExtension(name, d)
+		info.FilenameExtension = d.FilenameExtension()
+	}
+	return info, true
+}
+
+// InfoFromURL returns the static metadata of the driver of url,
+// without connecting to it.
+func InfoFromURL(url string) (Info, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return Info{}, err
+	}
+	info, ok := GetDriverInfo(u.Scheme)
+	if !ok {
+		return Info{}, fmt.Errorf("Driver '%s' not found.", u.Scheme)
+	}
+	return info, nil
+}
+
 // Drivers returns a sorted list of the names of the registered drivers.
 func Drivers() []string {
 	driversMu.Lock()
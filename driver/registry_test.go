@@ -0,0 +1,57 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/jfrog/go-dbmigrate/file"
+)
+
+type infoTestDriver struct{}
+
+func (d *infoTestDriver) Initialize(url string, initOptions ...func(Driver)) error {
+	panic("create must not initialize the driver")
+}
+func (d *infoTestDriver) Close() error                               { return nil }
+func (d *infoTestDriver) FilenameExtension() string                  { return "test" }
+func (d *infoTestDriver) Migrate(f file.File, pipe chan interface{}) { close(pipe) }
+func (d *infoTestDriver) Version() (uint64, error)                   { return 0, nil }
+
+func init() {
+	RegisterDriver("info-test", NewDriverGenerator(
+		func() Driver { return &infoTestDriver{} }).WithInfo(Info{
+		FilenameExtension: "test",
+		Description:       "Test driver",
+		URLFormat:         "info-test://host",
+	}))
+	RegisterDriver("info-test-legacy", NewDriverGenerator(
+		func() Driver { return &infoTestDriver{} }))
+}
+
+func TestDriverInfo(t *testing.T) {
+	tests := []struct {
+		url  string
+		info Info
+		err  bool
+	}{
+		{url: "info-test://host/db", info: Info{FilenameExtension: "test", Description: "Test driver", URLFormat: "info-test://host"}},
+		{url: "info-test-legacy://", info: Info{FilenameExtension: "test"}},
+		{url: "info-test-missing://host", err: true},
+		{url: "://host", err: true},
+	}
+	for _, test := range tests {
+		info, err := InfoFromURL(test.url)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error for %v", test.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", test.url, err)
+			continue
+		}
+		if info != test.info {
+			t.Errorf("Expected %+v for %v, got %+v", test.info, test.url, info)
+		}
+	}
+}
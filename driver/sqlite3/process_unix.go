@@ -0,0 +1,18 @@
+//go:build !windows
+
+package sqlite3
+
+import (
+	"os"
+	"syscall"
+)
+
+// processExists reports whether a process with pid is running.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
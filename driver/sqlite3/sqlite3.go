@@ -2,10 +2,15 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jfrog/go-dbmigrate/driver"
 	"github.com/jfrog/go-dbmigrate/file"
@@ -14,12 +19,83 @@ import (
 )
 
 type Driver struct {
-	db *sql.DB
+	db              *sql.DB
+	migrationsTable string
+	lockKey         string
+	lockTimeout     time.Duration
+	leaseStore      *driver.SQLLeaseStore
+	leaseLock       *driver.LeaseLock
+	ownsDB          bool
+	lockFile        string
+	isLocked        bool
 }
 
 const tableName = "schema_migration"
 
+// Config holds the settings of a Driver created by WithInstance.
+type Config struct {
+	// MigrationsTable is the name of the table that stores the applied
+	// migration versions. Defaults to schema_migration.
+	MigrationsTable string
+
+	// LockKey tells the lock files of applications sharing a database
+	// apart. Defaults to the migrations table.
+	LockKey string
+
+	// LockTimeout, if set, is how long to wait for the lock file,
+	// regardless of the timeout passed to Lock.
+	LockTimeout time.Duration
+
+	// LeaseLock makes the Driver use a lease in the <migrations table>_lock
+	// table instead of its lock file, e.g. behind a connection pooler.
+	LeaseLock bool
+
+	// LeaseTTL is how long the lease is valid unless it's renewed.
+	// Defaults to driver.DefaultLeaseTTL.
+	LeaseTTL time.Duration
+}
+
+// WithInstance returns a Driver that runs migrations on db, an existing
+// connection pool to a SQLite database. Close doesn't close db,
+// it's up to the caller to manage it.
+func WithInstance(db *sql.DB, config *Config) (*Driver, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	d := &Driver{
+		db:              db,
+		migrationsTable: config.MigrationsTable,
+		lockKey:         config.LockKey,
+		lockTimeout:     config.LockTimeout,
+	}
+	if d.migrationsTable == "" {
+		d.migrationsTable = tableName
+	}
+	if d.lockKey == "" {
+		d.lockKey = d.migrationsTable
+	}
+	if config.LeaseLock {
+		d.useLeaseLock(config.LeaseTTL)
+	}
+	ctx := context.Background()
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	if err := d.ensureVersionTableExists(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
 func (driver *Driver) Initialize(url string, initOptions ...func(driver.Driver)) error {
+	return driver.InitializeContext(context.Background(), url, initOptions...)
+}
+
+func (d *Driver) InitializeContext(ctx context.Context, url string, initOptions ...func(driver.Driver)) error {
+	url, options, err := driver.ParseOptions(url)
+	if err != nil {
+		return err
+	}
 	filename := strings.SplitN(url, "sqlite3://", 2)
 	if len(filename) != 2 {
 		return errors.New("invalid sqlite3:// scheme")
@@ -29,47 +105,268 @@ func (driver *Driver) Initialize(url string, initOptions ...func(driver.Driver))
 	if err != nil {
 		return err
 	}
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
 		return err
 	}
-	driver.db = db
+	d.db = db
+	d.ownsDB = true
+	d.migrationsTable = tableName
+	if options.MigrationsTable != "" {
+		d.migrationsTable = options.MigrationsTable
+	}
+	d.lockKey = d.migrationsTable
+	if options.LockKey != "" {
+		d.lockKey = options.LockKey
+	}
+	d.lockTimeout = options.LockTimeout
+	if options.LeaseLock {
+		d.useLeaseLock(options.LeaseTTL)
+	}
 
-	if err := driver.ensureVersionTableExists(); err != nil {
+	if err := d.ensureVersionTableExists(ctx); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (driver *Driver) Close() error {
+	if err := driver.Unlock(); err != nil {
+		return err
+	}
+	if !driver.ownsDB {
+		return nil
+	}
 	if err := driver.db.Close(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (driver *Driver) ensureVersionTableExists() error {
-	if _, err := driver.db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + " (version INTEGER PRIMARY KEY AUTOINCREMENT);"); err != nil {
+// Lock acquires the migration lock by creating a lock file next to the
+// database file, named after it and the lock key. Lock files of crashed
+// owners on this host are taken over. Databases that aren't backed by a
+// file can't be shared, so they aren't locked.
+func (d *Driver) Lock(ctx context.Context, timeout time.Duration) error {
+	if d.lockTimeout > 0 {
+		timeout = d.lockTimeout
+	}
+	if d.leaseLock != nil {
+		return d.leaseLock.Lock(ctx, timeout)
+	}
+
+	if d.isLocked {
+		return driver.ErrLocked
+	}
+
+	var dbFile string
+	if err := d.db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&dbFile); err != nil {
+		return err
+	}
+	if dbFile == "" {
+		d.isLocked = true
+		return nil
+	}
+
+	lockFile := dbFile + "." + d.lockKey + ".lock"
+	owner := driver.NewLockOwner()
+	err := driver.PollLock(ctx, timeout, func(ctx context.Context) (bool, error) {
+		for {
+			f, err := os.OpenFile(lockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if os.IsExist(err) {
+				removed, err := removeStaleLockFile(lockFile, owner)
+				if err != nil || !removed {
+					return false, err
+				}
+				continue
+			} else if err != nil {
+				return false, err
+			}
+			defer f.Close()
+			_, err = f.WriteString(owner + "\n" + time.Now().UTC().Format(time.RFC3339) + "\n")
+			return true, err
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	d.lockFile = lockFile
+	d.isLocked = true
+	return nil
+}
+
+// staleLockFile reports whether content is the content of a lock file
+// whose owner has crashed: it ran on this host and its process is gone.
+// The owners of lock files of other hosts can't be checked.
+func staleLockFile(content string) bool {
+	// <hostname>:<pid>:<suffix>, see driver.NewLockOwner
+	owner := strings.SplitN(content, "\n", 2)[0]
+	parts := strings.Split(owner, ":")
+	if len(parts) < 3 {
+		return false
+	}
+	pid, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil || pid == os.Getpid() {
+		return false
+	}
+	if hostname, _ := os.Hostname(); hostname != strings.Join(parts[:len(parts)-2], ":") {
+		return false
+	}
+	return !processExists(pid)
+}
+
+// removeStaleLockFile removes lockFile if it's stale and reports whether
+// it did. Only one process at a time takes lock files over: it creates a
+// guard file next to lockFile first, then checks lockFile again, as it may
+// have been taken over and replaced by a fresh lock file meanwhile. A guard
+// file left behind by a crash during the takeover has to be removed
+// manually.
+func removeStaleLockFile(lockFile, owner string) (bool, error) {
+	content, err := ioutil.ReadFile(lockFile)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !staleLockFile(string(content)) {
+		return false, nil
+	}
+
+	guardFile := lockFile + ".takeover"
+	guard, err := os.OpenFile(guardFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		// taken over by another process right now
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer os.Remove(guardFile)
+	_, err = guard.WriteString(owner + "\n" + time.Now().UTC().Format(time.RFC3339) + "\n")
+	if cerr := guard.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	content, err = ioutil.ReadFile(lockFile)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !staleLockFile(string(content)) {
+		return false, nil
+	}
+	if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *Driver) Unlock() error {
+	if d.leaseLock != nil {
+		return d.leaseLock.Unlock()
+	}
+	if !d.isLocked {
+		return nil
+	}
+	d.isLocked = false
+	if d.lockFile == "" {
+		return nil
+	}
+	lockFile := d.lockFile
+	d.lockFile = ""
+	if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// useLeaseLock makes the driver use a lease in the <migrations table>_lock
+// table instead of its lock file.
+func (d *Driver) useLeaseLock(ttl time.Duration) {
+	d.leaseStore = &driver.SQLLeaseStore{DB: d.db, Table: d.migrationsTable + "_lock", DollarPlaceholders: false}
+	d.leaseLock = driver.NewLeaseLock(d.leaseStore, d.lockKey, ttl)
+}
+
+func (d *Driver) Lease(ctx context.Context) (*driver.Lease, error) {
+	if d.leaseLock == nil {
+		return nil, driver.ErrNoLease
+	}
+	return d.leaseLock.Lease(ctx)
+}
+
+func (d *Driver) BreakLease(ctx context.Context) error {
+	if d.leaseLock == nil {
+		return driver.ErrNoLease
+	}
+	return d.leaseLock.BreakLease(ctx)
+}
+
+func (d *Driver) LeaseLost() <-chan struct{} {
+	if d.leaseLock == nil {
+		return nil
+	}
+	return d.leaseLock.Lost()
+}
+
+func (driver *Driver) ensureVersionTableExists(ctx context.Context) error {
+	if _, err := driver.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+driver.migrationsTable+" (version INTEGER PRIMARY KEY AUTOINCREMENT);"); err != nil {
+		return err
+	}
+	if err := driver.ensureChecksumColumnExists(ctx); err != nil {
+		return err
+	}
+	if _, err := driver.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+driver.historyTable()+" (id INTEGER PRIMARY KEY AUTOINCREMENT, version INTEGER NOT NULL, name TEXT NOT NULL, direction TEXT NOT NULL, applied_at TIMESTAMP NOT NULL, duration_ms INTEGER NOT NULL, hostname TEXT NOT NULL, tool_version TEXT NOT NULL, label TEXT NOT NULL);"); err != nil {
 		return err
 	}
+	if driver.leaseStore != nil {
+		return driver.leaseStore.CreateTable(ctx)
+	}
 	return nil
 }
 
+// ensureChecksumColumnExists adds the checksum column to tables
+// created before checksums were stored.
+func (driver *Driver) ensureChecksumColumnExists(ctx context.Context) error {
+	var count int
+	err := driver.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'checksum'", driver.migrationsTable).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = driver.db.ExecContext(ctx, "ALTER TABLE "+driver.migrationsTable+" ADD COLUMN checksum TEXT NOT NULL DEFAULT ''")
+	return err
+}
+
 func (driver *Driver) FilenameExtension() string {
 	return "sql"
 }
 
 func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+func (driver *Driver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
 	defer close(pipe)
 	pipe <- f
 
-	tx, err := driver.db.Begin()
+	tx, err := driver.db.BeginTx(ctx, nil)
 	if err != nil {
 		pipe <- err
 		return
 	}
 
 	if f.Direction == direction.Up {
-		if _, err := tx.Exec("INSERT INTO "+tableName+" (version) VALUES (?)", f.Version); err != nil {
+		checksum, err := f.Checksum()
+		if err != nil {
+			pipe <- err
+			if err := tx.Rollback(); err != nil {
+				pipe <- err
+			}
+			return
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+driver.migrationsTable+" (version, checksum) VALUES (?, ?)", f.Version, checksum); err != nil {
 			pipe <- err
 			if err := tx.Rollback(); err != nil {
 				pipe <- err
@@ -77,7 +374,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 			return
 		}
 	} else if f.Direction == direction.Down {
-		if _, err := tx.Exec("DELETE FROM "+tableName+" WHERE version=?", f.Version); err != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+driver.migrationsTable+" WHERE version=?", f.Version); err != nil {
 			pipe <- err
 			if err := tx.Rollback(); err != nil {
 				pipe <- err
@@ -91,7 +388,7 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 		return
 	}
 
-	if _, err := tx.Exec(string(f.Content)); err != nil {
+	if _, err := tx.ExecContext(ctx, string(f.Content)); err != nil {
 		sqliteErr, isErr := err.(sqlite3.Error)
 
 		if isErr {
@@ -114,8 +411,12 @@ func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
 }
 
 func (driver *Driver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *Driver) VersionContext(ctx context.Context) (uint64, error) {
 	var version uint64
-	err := driver.db.QueryRow("SELECT version FROM " + tableName + " ORDER BY version DESC LIMIT 1").Scan(&version)
+	err := driver.db.QueryRowContext(ctx, "SELECT version FROM "+driver.migrationsTable+" ORDER BY version DESC LIMIT 1").Scan(&version)
 	switch {
 	case err == sql.ErrNoRows:
 		return 0, nil
@@ -126,7 +427,114 @@ func (driver *Driver) Version() (uint64, error) {
 	}
 }
 
+func (driver *Driver) AppliedVersions(ctx context.Context) ([]uint64, error) {
+	rows, err := driver.db.QueryContext(ctx, "SELECT version FROM "+driver.migrationsTable+" ORDER BY version ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := make([]uint64, 0)
+	for rows.Next() {
+		var version uint64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (driver *Driver) Checksums(ctx context.Context) (map[uint64]string, error) {
+	rows, err := driver.db.QueryContext(ctx, "SELECT version, checksum FROM "+driver.migrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	checksums := make(map[uint64]string)
+	for rows.Next() {
+		var version uint64
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[version] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+func (driver *Driver) SetChecksum(ctx context.Context, version uint64, checksum string) error {
+	_, err := driver.db.ExecContext(ctx, "UPDATE "+driver.migrationsTable+" SET checksum = ? WHERE version = ?", checksum, version)
+	return err
+}
+
+func (driver *Driver) historyTable() string {
+	return driver.migrationsTable + "_history"
+}
+
+func (d *Driver) AddHistory(ctx context.Context, entry driver.HistoryEntry) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO "+d.historyTable()+" (version, name, direction, applied_at, duration_ms, hostname, tool_version, label) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.Version, entry.Name, entry.Direction.String(), entry.AppliedAt, entry.Duration.Milliseconds(), entry.Hostname, entry.ToolVersion, entry.Label)
+	return err
+}
+
+func (d *Driver) History(ctx context.Context) ([]driver.HistoryEntry, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT version, name, direction, applied_at, duration_ms, hostname, tool_version, label FROM "+d.historyTable()+" ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := make([]driver.HistoryEntry, 0)
+	for rows.Next() {
+		var entry driver.HistoryEntry
+		var dir string
+		var appliedAt time.Time
+		var durationMs int64
+		if err := rows.Scan(&entry.Version, &entry.Name, &dir, &appliedAt, &durationMs, &entry.Hostname, &entry.ToolVersion, &entry.Label); err != nil {
+			return nil, err
+		}
+		if entry.Direction, err = direction.Parse(dir); err != nil {
+			return nil, err
+		}
+		entry.AppliedAt = appliedAt
+		entry.Duration = time.Duration(durationMs) * time.Millisecond
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+func (d *Driver) Baseline(ctx context.Context, files file.Files) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+d.migrationsTable).Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return driver.ErrAlreadyMigrated
+	}
+	for _, f := range files {
+		checksum, err := f.Checksum()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+d.migrationsTable+" (version, checksum) VALUES (?, ?)", f.Version, checksum); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func init() {
 	driver.RegisterDriver("sqlite3", driver.NewDriverGenerator(
-		func() driver.Driver { return &Driver{} }))
+		func() driver.Driver { return &Driver{} }).WithInfo(driver.Info{
+		FilenameExtension: "sql",
+		Description:       "SQLite 3",
+		URLFormat:         "sqlite3://path/to/database.sqlite",
+	}))
 }
@@ -1,9 +1,17 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
 	"testing"
+	"time"
 
+	"github.com/jfrog/go-dbmigrate/driver"
 	"github.com/jfrog/go-dbmigrate/file"
 	"github.com/jfrog/go-dbmigrate/migrate/direction"
 	pipep "github.com/jfrog/go-dbmigrate/pipe"
@@ -93,3 +101,257 @@ func TestMigrate(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestWithInstance(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// every connection to :memory: opens a new database
+	db.SetMaxOpenConns(1)
+
+	d, err := WithInstance(db, &Config{MigrationsTable: "custom_migrations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipe := pipep.New()
+	go d.Migrate(file.File{
+		Path:      "/foobar",
+		FileName:  "001_foobar.up.sql",
+		Version:   1,
+		Name:      "foobar",
+		Direction: direction.Up,
+		Content:   []byte(`CREATE TABLE yolo (id INTEGER PRIMARY KEY AUTOINCREMENT);`),
+	}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal("Expected Close not to close the instance:", err)
+	}
+
+	var version uint64
+	if err := db.QueryRow("SELECT version FROM custom_migrations").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("Expected version 1, got %v", version)
+	}
+}
+
+func TestChecksumColumnIsAdded(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// a table created before checksums were stored
+	if _, err := db.Exec("CREATE TABLE " + tableName + " (version INTEGER PRIMARY KEY AUTOINCREMENT); INSERT INTO " + tableName + " (version) VALUES (1);"); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := WithInstance(db, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	checksums, err := d.Checksums(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksum, ok := checksums[1]; !ok || checksum != "" {
+		t.Fatalf("Expected empty checksum for version 1, got %v", checksums)
+	}
+
+	if err := d.SetChecksum(ctx, 1, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	checksums, err = d.Checksums(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksums[1] != "abc" {
+		t.Fatalf("Expected checksum abc for version 1, got %v", checksums)
+	}
+}
+
+func TestTimestampVersion(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	d, err := WithInstance(db, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipe := pipep.New()
+	go d.Migrate(file.File{
+		Path:      "/foobar",
+		FileName:  "20240131154500_foobar.up.sql",
+		Version:   20240131154500,
+		Name:      "foobar",
+		Direction: direction.Up,
+		Content:   []byte("CREATE TABLE yolo (id INTEGER PRIMARY KEY AUTOINCREMENT);"),
+	}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	version, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 20240131154500 {
+		t.Fatalf("Expected version 20240131154500, got %v", version)
+	}
+}
+
+func TestLock(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "sqlite3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	driverUrl := "sqlite3://" + path.Join(tmpdir, "migrate.db")
+	ctx := context.Background()
+
+	d1, d2 := &Driver{}, &Driver{}
+	for _, d := range []*Driver{d1, d2} {
+		if err := d.Initialize(driverUrl); err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+	}
+
+	if err := d1.Lock(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Lock(ctx, 300*time.Millisecond); err != driver.ErrLocked {
+		t.Fatalf("Expected ErrLocked while the lock is held, got %v", err)
+	}
+	if err := d1.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Lock(ctx, time.Second); err != nil {
+		t.Fatalf("Expected lock to be acquired once released, got %v", err)
+	}
+	if err := d2.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaleLockFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "sqlite3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbFile := path.Join(tmpdir, "migrate.db")
+	lockFile := dbFile + "." + tableName + ".lock"
+	ctx := context.Background()
+
+	d := &Driver{}
+	if err := d.Initialize("sqlite3://" + dbFile); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// a process that has exited, like a crashed migration
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		owner string
+		stale bool
+	}{
+		{owner: fmt.Sprintf("%s:%d:abcd", hostname, cmd.Process.Pid), stale: true},
+		{owner: fmt.Sprintf("%s:%d:abcd", hostname, os.Getpid()), stale: false},
+		{owner: fmt.Sprintf("other-host:%d:abcd", cmd.Process.Pid), stale: false},
+		{owner: "garbage", stale: false},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(lockFile, []byte(test.owner+"\n2024-01-31T15:45:00Z\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := d.Lock(ctx, 100*time.Millisecond)
+		if test.stale {
+			if err != nil {
+				t.Errorf("Expected the stale lock file of %v to be taken over, got %v", test.owner, err)
+			}
+			if err := d.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+		} else if err != driver.ErrLocked {
+			t.Errorf("Expected ErrLocked for the lock file of %v, got %v", test.owner, err)
+		}
+	}
+}
+
+func TestLeaseLock(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "sqlite3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	driverUrl := "sqlite3://" + path.Join(tmpdir, "migrate.db") + "?x-lock=lease&x-lease-ttl=1s"
+	ctx := context.Background()
+
+	d1, d2 := &Driver{}, &Driver{}
+	for _, d := range []*Driver{d1, d2} {
+		if err := d.Initialize(driverUrl); err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+	}
+
+	if err := d1.Lock(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// outlive the TTL to see that the lease is renewed
+	time.Sleep(1500 * time.Millisecond)
+	if err := d2.Lock(ctx, 300*time.Millisecond); err != driver.ErrLocked {
+		t.Fatalf("Expected ErrLocked while the lease is held, got %v", err)
+	}
+
+	lease, err := d2.Lease(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease == nil || lease.Key != tableName || lease.Expired(time.Now()) {
+		t.Fatalf("Expected a valid lease of %v, got %+v", tableName, lease)
+	}
+
+	if err := d2.BreakLease(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Lock(ctx, time.Second); err != nil {
+		t.Fatalf("Expected lock to be acquired once the lease is broken, got %v", err)
+	}
+	// let the heartbeat of d1 notice
+	time.Sleep(500 * time.Millisecond)
+	if err := d1.Unlock(); err != driver.ErrLeaseLost {
+		t.Fatalf("Expected ErrLeaseLost, got %v", err)
+	}
+	if err := d2.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if lease, err := d2.Lease(ctx); err != nil || lease != nil {
+		t.Fatalf("Expected no lease after unlocking, got %+v, %v", lease, err)
+	}
+}
@@ -1,9 +1,15 @@
 package driver
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
 	"fmt"
 	"hash/crc32"
+	"os"
 	"strings"
+	"time"
 )
 
 const advisoryLockIDSalt uint = 1486364155
@@ -31,3 +37,61 @@ func CanIgnoreError(err error) bool {
 
 	return false
 }
+
+// ErrPoolTooSmall is returned by WithInstance of drivers that hold their
+// migration lock on a connection of its own, or renew its lease in the
+// background, if the connection pool allows a single open connection:
+// migrations would wait for it forever.
+var ErrPoolTooSmall = errors.New("the connection pool must allow at least 2 open connections, one is taken by the migration lock")
+
+// CheckPoolSize returns ErrPoolTooSmall if db allows a single open
+// connection, see sql.DB.SetMaxOpenConns.
+func CheckPoolSize(db *sql.DB) error {
+	if db.Stats().MaxOpenConnections == 1 {
+		return ErrPoolTooSmall
+	}
+	return nil
+}
+
+// lockPollInterval is how often PollLock tries to acquire a lock.
+const lockPollInterval = 250 * time.Millisecond
+
+// PollLock calls tryLock until it acquires the lock. It returns ErrLocked
+// once timeout has passed, or ctx.Err() once ctx is done. A timeout of 0
+// waits until ctx is done. It's meant for drivers implementing Locker
+// whose backend can't wait for a lock by itself.
+func PollLock(ctx context.Context, timeout time.Duration, tryLock func(ctx context.Context) (acquired bool, err error)) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		acquired, err := tryLock(ctx)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return ErrLocked
+		case <-ticker.C:
+		}
+	}
+}
+
+// NewLockOwner returns an identifier for the holder of a lock, made of
+// the hostname, the process id and a random suffix.
+func NewLockOwner() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s:%d:%x", hostname, os.Getpid(), suffix)
+}
@@ -0,0 +1,217 @@
+// Package event has typed events that describe the progress of migrations.
+//
+// Drivers and the migrate package report progress through a pipe
+// (see package pipe), which carries values of mixed types. FromPipe
+// and Observe translate these values into events, so that consumers
+// don't have to guess what a value means.
+package event
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jfrog/go-dbmigrate/file"
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+// Event is implemented by all events of this package.
+type Event interface {
+	String() string
+	isEvent()
+}
+
+func (MigrationStarted) isEvent()  {}
+func (MigrationFinished) isEvent() {}
+func (MethodInvoked) isEvent()     {}
+func (Notice) isEvent()            {}
+func (Error) isEvent()             {}
+
+// MigrationStarted is emitted before a migration file is applied.
+type MigrationStarted struct {
+	File file.File
+}
+
+func (e MigrationStarted) String() string {
+	return fmt.Sprintf("%s %s", directionSign(e.File.Direction), e.File.FileName)
+}
+
+// MigrationFinished is emitted after a migration file has been applied,
+// or once it failed to apply.
+type MigrationFinished struct {
+	File     file.File
+	Duration time.Duration
+	Failed   bool
+}
+
+func (e MigrationFinished) String() string {
+	status := "finished"
+	if e.Failed {
+		status = "failed"
+	}
+	return fmt.Sprintf("%s %s %s after %v", directionSign(e.File.Direction), e.File.FileName, status, e.Duration)
+}
+
+// MethodInvoked is emitted before a go methods driver invokes a
+// migration method. The drivers send it through the pipe with the Method
+// only, the Translator adds the migration file that is running.
+type MethodInvoked struct {
+	Version  uint64
+	FileName string
+	Method   string
+}
+
+// String returns the method name.
+func (e MethodInvoked) String() string {
+	return e.Method
+}
+
+// Notice is an informational message, e.g. about a received interrupt.
+type Notice struct {
+	Message string
+}
+
+func (e Notice) String() string {
+	return e.Message
+}
+
+// Error is emitted for every error sent through the pipe. Version and
+// FileName refer to the migration file that was running, if any.
+type Error struct {
+	Version  uint64
+	FileName string
+	Err      error
+}
+
+func (e Error) String() string {
+	return e.Error()
+}
+
+func (e Error) Error() string {
+	if e.FileName == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.FileName, e.Err)
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+func directionSign(d direction.Direction) string {
+	if d == direction.Down {
+		return "<"
+	}
+	return ">"
+}
+
+// Observer receives events.
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc is an adapter to allow the use of ordinary functions as Observer.
+type ObserverFunc func(e Event)
+
+// Observe calls f(e).
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// Translator turns the values sent through a pipe into events.
+// A Translator keeps track of the migration file that is currently
+// running, so it must see all values of a pipe in order.
+type Translator struct {
+	current *file.File
+	started time.Time
+	failed  bool
+}
+
+// Translate returns the events for a value received from a pipe.
+func (t *Translator) Translate(item interface{}) []Event {
+	switch item := item.(type) {
+	case file.File:
+		events := t.Flush()
+		t.current = &item
+		t.started = time.Now()
+		t.failed = false
+		return append(events, MigrationStarted{File: item})
+
+	case Error:
+		if t.current != nil {
+			t.failed = true
+		}
+		return []Event{item}
+
+	case error:
+		e := Error{Err: item}
+		if t.current != nil {
+			t.failed = true
+			e.Version = t.current.Version
+			e.FileName = t.current.FileName
+		}
+		return []Event{e}
+
+	case MethodInvoked:
+		if t.current != nil && item.FileName == "" {
+			item.Version = t.current.Version
+			item.FileName = t.current.FileName
+		}
+		return []Event{item}
+
+	case Event:
+		return []Event{item}
+
+	case string:
+		return []Event{Notice{Message: item}}
+
+	default:
+		return []Event{Notice{Message: fmt.Sprint(item)}}
+	}
+}
+
+// Flush finishes the migration file that is currently running, if any.
+// It must be called once the pipe is closed.
+func (t *Translator) Flush() []Event {
+	if t.current == nil {
+		return nil
+	}
+	e := MigrationFinished{
+		File:     *t.current,
+		Duration: time.Since(t.started),
+		Failed:   t.failed,
+	}
+	t.current = nil
+	return []Event{e}
+}
+
+// FromPipe reads pipe until it's closed and sends the translated
+// events to the returned channel, which is closed afterwards.
+func FromPipe(pipe chan interface{}) chan Event {
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		t := &Translator{}
+		for item := range pipe {
+			for _, e := range t.Translate(item) {
+				events <- e
+			}
+		}
+		for _, e := range t.Flush() {
+			events <- e
+		}
+	}()
+	return events
+}
+
+// Observe reads pipe until it's closed and passes all events to o.
+// It returns false if an error was received.
+func Observe(pipe chan interface{}, o Observer) (ok bool) {
+	ok = true
+	for e := range FromPipe(pipe) {
+		if _, isErr := e.(Error); isErr {
+			ok = false
+		}
+		o.Observe(e)
+	}
+	return ok
+}
@@ -0,0 +1,75 @@
+package event
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jfrog/go-dbmigrate/file"
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+	pipep "github.com/jfrog/go-dbmigrate/pipe"
+)
+
+func TestFromPipe(t *testing.T) {
+	up := file.File{FileName: "001_foobar.up.sql", Version: 1, Direction: direction.Up}
+	up2 := file.File{FileName: "002_foobar.up.sql", Version: 2, Direction: direction.Up}
+	someErr := errors.New("some error")
+
+	pipe := pipep.New()
+	go func() {
+		pipe <- up
+		pipe <- MethodInvoked{Method: "V001_foobar_up"}
+		pipe <- up2
+		pipe <- "some_notice"
+		pipe <- someErr
+		close(pipe)
+	}()
+
+	events := make([]Event, 0)
+	for e := range FromPipe(pipe) {
+		events = append(events, e)
+	}
+
+	if len(events) != 7 {
+		t.Fatalf("Expected 7 events, got %v: %v", len(events), events)
+	}
+	if e, ok := events[0].(MigrationStarted); !ok || e.File.Version != 1 {
+		t.Errorf("Expected MigrationStarted for version 1, got %v", events[0])
+	}
+	if e, ok := events[1].(MethodInvoked); !ok || e.Method != "V001_foobar_up" || e.Version != 1 || e.FileName != up.FileName {
+		t.Errorf("Expected MethodInvoked, got %v", events[1])
+	}
+	if e, ok := events[2].(MigrationFinished); !ok || e.File.Version != 1 || e.Failed {
+		t.Errorf("Expected successful MigrationFinished for version 1, got %v", events[2])
+	}
+	if e, ok := events[3].(MigrationStarted); !ok || e.File.Version != 2 {
+		t.Errorf("Expected MigrationStarted for version 2, got %v", events[3])
+	}
+	if e, ok := events[4].(Notice); !ok || e.Message != "some_notice" {
+		t.Errorf("Expected Notice, got %v", events[4])
+	}
+	if e, ok := events[5].(Error); !ok || e.Version != 2 || e.FileName != up2.FileName || !errors.Is(e, someErr) {
+		t.Errorf("Expected Error for version 2, got %v", events[5])
+	}
+	if e, ok := events[6].(MigrationFinished); !ok || e.File.Version != 2 || !e.Failed {
+		t.Errorf("Expected failed MigrationFinished for version 2, got %v", events[6])
+	}
+}
+
+func TestObserve(t *testing.T) {
+	pipe := pipep.New()
+	go func() {
+		pipe <- file.File{FileName: "001_foobar.up.sql", Version: 1, Direction: direction.Up}
+		close(pipe)
+	}()
+
+	received := 0
+	ok := Observe(pipe, ObserverFunc(func(e Event) {
+		received += 1
+	}))
+	if !ok {
+		t.Error("Expected ok")
+	}
+	if received != 2 {
+		t.Errorf("Expected 2 events, got %v", received)
+	}
+}
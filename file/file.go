@@ -3,12 +3,17 @@ package file
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/jfrog/go-dbmigrate/migrate/direction"
 	"go/token"
+	"io/fs"
 	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -29,7 +34,12 @@ type File struct {
 	// absolute path to file
 	Path string
 
-	// the name of the file
+	// the file system that holds the file, if it has been read with
+	// ReadMigrationFilesFS. nil for files that are read from Path.
+	FS fs.FS
+
+	// the name of the file, with the path of its directory relative to
+	// the migrations directory, e.g. 2023/001_initial.up.sql
 	FileName string
 
 	// version parsed from filename
@@ -63,10 +73,17 @@ type MigrationFile struct {
 // MigrationFiles is a slice of MigrationFiles
 type MigrationFiles []MigrationFile
 
-// ReadContent reads the file's content if the content is empty
+// ReadContent reads the file's content if the content is empty,
+// from FS if it's set, from Path otherwise.
 func (f *File) ReadContent() error {
 	if len(f.Content) == 0 {
-		content, err := ioutil.ReadFile(path.Join(f.Path, f.FileName))
+		var content []byte
+		var err error
+		if f.FS != nil {
+			content, err = fs.ReadFile(f.FS, f.FileName)
+		} else {
+			content, err = ioutil.ReadFile(path.Join(f.Path, f.FileName))
+		}
 		if err != nil {
 			return err
 		}
@@ -75,6 +92,16 @@ func (f *File) ReadContent() error {
 	return nil
 }
 
+// Checksum returns the hex encoded SHA-256 hash of the file's content.
+// It reads the content if it's empty.
+func (f *File) Checksum() (string, error) {
+	if err := f.ReadContent(); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(f.Content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 // ToFirstFrom fetches all (down) migration files including the migration file
 // of the current version to the very first migration file.
 func (mf *MigrationFiles) ToFirstFrom(version uint64) (Files, error) {
@@ -149,10 +176,129 @@ func (mf *MigrationFiles) From(version uint64, relativeN int) (Files, error) {
 	return files, nil
 }
 
-// ReadMigrationFiles reads all migration files from a given path
+// ToVersion fetches the migration files to migrate from version to toVersion,
+// selected by version range rather than by count, so gaps in the version
+// numbers don't matter. Migrating up includes toVersion, migrating down
+// includes version but not toVersion. toVersion must be 0 or the version
+// of a migration file, and every file in the range must have a migration
+// file for the direction.
+func (mf *MigrationFiles) ToVersion(version, toVersion uint64) (Files, error) {
+	if toVersion != 0 {
+		found := false
+		for _, migrationFile := range *mf {
+			if migrationFile.Version == toVersion {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("No migration file for version %v", toVersion)
+		}
+	}
+
+	files := make(Files, 0)
+	if toVersion > version {
+		sort.Sort(mf)
+		for _, migrationFile := range *mf {
+			if migrationFile.Version > version && migrationFile.Version <= toVersion {
+				if migrationFile.UpFile == nil {
+					return nil, fmt.Errorf("No up migration file for version %v", migrationFile.Version)
+				}
+				files = append(files, *migrationFile.UpFile)
+			}
+		}
+	} else if toVersion < version {
+		sort.Sort(sort.Reverse(mf))
+		for _, migrationFile := range *mf {
+			if migrationFile.Version <= version && migrationFile.Version > toVersion {
+				if migrationFile.DownFile == nil {
+					return nil, fmt.Errorf("No down migration file for version %v", migrationFile.Version)
+				}
+				files = append(files, *migrationFile.DownFile)
+			}
+		}
+	}
+	return files, nil
+}
+
+// Unapplied fetches all up migration files whose version is not in applied,
+// in ascending order.
+func (mf *MigrationFiles) Unapplied(applied []uint64) Files {
+	isApplied := versionSet(applied)
+	sort.Sort(mf)
+	files := make(Files, 0)
+	for _, migrationFile := range *mf {
+		if !isApplied[migrationFile.Version] && migrationFile.UpFile != nil {
+			files = append(files, *migrationFile.UpFile)
+		}
+	}
+	return files
+}
+
+// Applied fetches all down migration files whose version is in applied,
+// in descending order.
+func (mf *MigrationFiles) Applied(applied []uint64) Files {
+	isApplied := versionSet(applied)
+	sort.Sort(sort.Reverse(mf))
+	files := make(Files, 0)
+	for _, migrationFile := range *mf {
+		if isApplied[migrationFile.Version] && migrationFile.DownFile != nil {
+			files = append(files, *migrationFile.DownFile)
+		}
+	}
+	return files
+}
+
+func versionSet(versions []uint64) map[uint64]bool {
+	set := make(map[uint64]bool, len(versions))
+	for _, v := range versions {
+		set[v] = true
+	}
+	return set
+}
+
+// ReadMigrationFiles reads all migration files from a given path and its
+// subdirectories, see ReadMigrationFilesFS.
 func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files MigrationFiles, err error) {
-	// find all migration files in path
-	ioFiles, err := ioutil.ReadDir(path)
+	files, err = ReadMigrationFilesFS(os.DirFS(path), filenameRegex)
+	if pathErr, ok := err.(*fs.PathError); ok {
+		// report the directory rather than "."
+		pathErr.Path = filepath.Join(path, pathErr.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, migrationFile := range files {
+		if migrationFile.UpFile != nil {
+			migrationFile.UpFile.Path = path
+		}
+		if migrationFile.DownFile != nil {
+			migrationFile.DownFile.Path = path
+		}
+	}
+	return files, nil
+}
+
+// ReadMigrationFilesFS reads all migration files from the root directory
+// of fsys, e.g. an embed.FS, and its subdirectories. Use fs.Sub for
+// migration files in a subdirectory. Versions are unique across all
+// directories, directories whose names start with a dot are skipped.
+func ReadMigrationFilesFS(fsys fs.FS, filenameRegex *regexp.Regexp) (files MigrationFiles, err error) {
+	// find all migration files in the root directory and below
+	filenames := make([]string, 0)
+	err = fs.WalkDir(fsys, ".", func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filename != "." && strings.HasPrefix(entry.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		filenames = append(filenames, filename)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -164,18 +310,18 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 	}
 	tmpFiles := make([]*tmpFile, 0)
 	tmpFileMap := map[uint64]map[direction.Direction]tmpFile{}
-	for _, file := range ioFiles {
-		version, name, d, err := parseFilenameSchema(file.Name(), filenameRegex)
+	for _, filename := range filenames {
+		version, name, d, err := ParseFilenameSchema(path.Base(filename), filenameRegex)
 		if err == nil {
 			if _, ok := tmpFileMap[version]; !ok {
 				tmpFileMap[version] = map[direction.Direction]tmpFile{}
 			}
 			if existing, ok := tmpFileMap[version][d]; !ok {
-				tmpFileMap[version][d] = tmpFile{version: version, name: name, filename: file.Name(), d: d}
+				tmpFileMap[version][d] = tmpFile{version: version, name: name, filename: filename, d: d}
 			} else {
-				return nil, fmt.Errorf("duplicate migration file version %d : %q and %q", version, existing.filename, file.Name())
+				return nil, fmt.Errorf("duplicate migration file version %d : %q and %q", version, existing.filename, filename)
 			}
-			tmpFiles = append(tmpFiles, &tmpFile{version, name, file.Name(), d})
+			tmpFiles = append(tmpFiles, &tmpFile{version, name, filename, d})
 		}
 	}
 
@@ -192,7 +338,7 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 			switch file.d {
 			case direction.Up:
 				migrationFile.UpFile = &File{
-					Path:      path,
+					FS:        fsys,
 					FileName:  file.filename,
 					Version:   file.version,
 					Name:      file.name,
@@ -202,7 +348,7 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 				lookFordirection = direction.Down
 			case direction.Down:
 				migrationFile.DownFile = &File{
-					Path:      path,
+					FS:        fsys,
 					FileName:  file.filename,
 					Version:   file.version,
 					Name:      file.name,
@@ -219,7 +365,7 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 					switch lookFordirection {
 					case direction.Up:
 						migrationFile.UpFile = &File{
-							Path:      path,
+							FS:        fsys,
 							FileName:  file2.filename,
 							Version:   file.version,
 							Name:      file2.name,
@@ -228,7 +374,7 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 						}
 					case direction.Down:
 						migrationFile.DownFile = &File{
-							Path:      path,
+							FS:        fsys,
 							FileName:  file2.filename,
 							Version:   file.version,
 							Name:      file2.name,
@@ -249,8 +395,9 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 	return newFiles, nil
 }
 
-// parseFilenameSchema parses the filename
-func parseFilenameSchema(filename string, filenameRegex *regexp.Regexp) (version uint64, name string, d direction.Direction, err error) {
+// ParseFilenameSchema parses the version, name and direction of a migration
+// file from its filename.
+func ParseFilenameSchema(filename string, filenameRegex *regexp.Regexp) (version uint64, name string, d direction.Direction, err error) {
 	matches := filenameRegex.FindStringSubmatch(filename)
 	if len(matches) != 4 {
 		return 0, "", 0, errors.New("Unable to parse filename schema")
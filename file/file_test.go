@@ -5,7 +5,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestParseFilenameSchema(t *testing.T) {
@@ -33,7 +36,7 @@ func TestParseFilenameSchema(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		version, name, migrate, err := parseFilenameSchema(test.filename, FilenameRegex(test.filenameExtension))
+		version, name, migrate, err := ParseFilenameSchema(test.filename, FilenameRegex(test.filenameExtension))
 		if test.expectErr && err == nil {
 			t.Fatal("Expected error, but got none.", test)
 		}
@@ -184,6 +187,54 @@ func TestFiles(t *testing.T) {
 		}
 	}
 
+	// test file.ToVersion()
+	var toVersionTests = []struct {
+		from        uint64
+		to          uint64
+		expectRange []uint64
+		expectErr   bool
+	}{
+		{0, 2, []uint64{1, 2}, false},
+		{2, 301, []uint64{101, 301}, false},
+		{1, 1, []uint64{}, false},
+		{101, 0, []uint64{101, 2, 1}, false},
+		{101, 1, []uint64{101, 2}, false},
+		{0, 3, nil, true},     // no file for version 3
+		{0, 401, nil, true},   // 401 has no up file
+		{301, 101, nil, true}, // 301 has no down file
+	}
+
+	for _, test := range toVersionTests {
+		rangeFiles, err := files.ToVersion(test.from, test.to)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("file.ToVersion(%v, %v): expected error", test.from, test.to)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("file.ToVersion(%v, %v): %v", test.from, test.to, err)
+		}
+		if len(rangeFiles) != len(test.expectRange) {
+			t.Fatalf("file.ToVersion(%v, %v): expected %v files, got %v.", test.from, test.to, len(test.expectRange), len(rangeFiles))
+		}
+		for i, version := range test.expectRange {
+			if rangeFiles[i].Version != version {
+				t.Fatal("file.ToVersion(): returned files dont match expectations", test.expectRange)
+			}
+		}
+	}
+
+	// test Unapplied and Applied
+	unapplied := files.Unapplied([]uint64{1, 101})
+	if len(unapplied) != 2 || unapplied[0].Version != 2 || unapplied[1].Version != 301 || unapplied[0].Direction != direction.Up {
+		t.Error("Unapplied() returned wrong files", unapplied)
+	}
+	applied := files.Applied([]uint64{1, 101})
+	if len(applied) != 2 || applied[0].Version != 101 || applied[1].Version != 1 || applied[0].Direction != direction.Down {
+		t.Error("Applied() returned wrong files", applied)
+	}
+
 	// test ToFirstFrom
 	tffFiles, err := files.ToFirstFrom(401)
 	if err != nil {
@@ -229,6 +280,77 @@ func TestDuplicateFiles(t *testing.T) {
 	}
 }
 
+func TestRecursiveFiles(t *testing.T) {
+	root, cleanFn, err := makeFiles("TestRecursiveFiles",
+		"2024/003_c.up.sql",
+		"2023/001_a.up.sql",
+		"2023/001_a.down.sql",
+		"002_b.up.sql",
+		"billing/2024/004_d.up.sql",
+		".old/005_e.up.sql",
+	)
+	defer cleanFn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ReadMigrationFiles(root, FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filenames []string
+	for _, migrationFile := range files {
+		filenames = append(filenames, migrationFile.UpFile.FileName)
+	}
+	expected := []string{"2023/001_a.up.sql", "002_b.up.sql", "2024/003_c.up.sql", "billing/2024/004_d.up.sql"}
+	if !reflect.DeepEqual(filenames, expected) {
+		t.Fatalf("Expected %v, got %v", expected, filenames)
+	}
+	if files[0].DownFile == nil || files[0].DownFile.FileName != "2023/001_a.down.sql" {
+		t.Errorf("Expected down file 2023/001_a.down.sql for version 1, got %v", files[0].DownFile)
+	}
+	if err := files[3].UpFile.ReadContent(); err != nil {
+		t.Error(err)
+	}
+
+	root2, cleanFn2, err := makeFiles("TestRecursiveFiles", "2023/001_a.up.sql", "2024/001_b.up.sql")
+	defer cleanFn2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadMigrationFiles(root2, FilenameRegex("sql"))
+	if err == nil || !strings.Contains(err.Error(), "2023/001_a.up.sql") || !strings.Contains(err.Error(), "2024/001_b.up.sql") {
+		t.Fatalf("Expected duplicate migration file error with both paths, got %v", err)
+	}
+}
+
+func TestReadMigrationFilesFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_create.up.sql":   {Data: []byte("CREATE TABLE t (id int);")},
+		"001_create.down.sql": {Data: []byte("DROP TABLE t;")},
+		"002_alter.up.sql":    {Data: []byte("ALTER TABLE t ADD c int;")},
+		"README.md":           {},
+	}
+
+	files, err := ReadMigrationFilesFS(fsys, FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Version != 1 || files[1].Version != 2 {
+		t.Fatalf("Expected versions 1 and 2, got %v", files)
+	}
+	if files[1].DownFile != nil {
+		t.Errorf("Expected no down file for version 2")
+	}
+
+	if err := files[0].UpFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if string(files[0].UpFile.Content) != "CREATE TABLE t (id int);" {
+		t.Errorf("Unexpected content %q", files[0].UpFile.Content)
+	}
+}
+
 // makeFiles takes an identifier, and a list of file names and uses them to create a temporary
 // directory populated with files named with the names passed in.  makeFiles returns the root
 // directory name, and a func suitable for a defer cleanup to remove the temporary files after
@@ -245,6 +367,9 @@ func makeFiles(testname string, names ...string) (root string, cleanup func(), e
 	}
 
 	for _, name := range names {
+		if err = os.MkdirAll(path.Join(root, path.Dir(name)), 0755); err != nil {
+			return
+		}
 		if err = ioutil.WriteFile(path.Join(root, name), nil, 0755); err != nil {
 			return
 		}
@@ -4,27 +4,47 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"strconv"
+	"text/tabwriter"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/jfrog/go-dbmigrate/driver"
 	_ "github.com/jfrog/go-dbmigrate/driver/bash"
 	_ "github.com/jfrog/go-dbmigrate/driver/cassandra"
+	_ "github.com/jfrog/go-dbmigrate/driver/generic"
+	_ "github.com/jfrog/go-dbmigrate/driver/mongodb"
 	_ "github.com/jfrog/go-dbmigrate/driver/mysql"
 	_ "github.com/jfrog/go-dbmigrate/driver/postgres"
 	_ "github.com/jfrog/go-dbmigrate/driver/sqlite3"
-	"github.com/jfrog/go-dbmigrate/file"
+	"github.com/jfrog/go-dbmigrate/event"
 	"github.com/jfrog/go-dbmigrate/migrate"
 	"github.com/jfrog/go-dbmigrate/migrate/direction"
 	pipep "github.com/jfrog/go-dbmigrate/pipe"
+	"github.com/jfrog/go-dbmigrate/source/archive"
+	_ "github.com/jfrog/go-dbmigrate/source/git"
+	_ "github.com/jfrog/go-dbmigrate/source/http"
 )
 
 var url = flag.String("url", os.Getenv("MIGRATE_URL"), "")
 var migrationsPath = flag.String("path", "", "")
+var sourceURL = flag.String("source", "", "")
 var version = flag.Bool("version", false, "Show migrate version")
+var allowOutOfOrder = flag.Bool("allow-out-of-order", false, "Apply migrations below the current version that haven't been applied")
+var label = flag.String("label", "", "Label recorded in the migration history, e.g. a git SHA")
+var output = flag.String("output", "text", "Output of the plan, status and history commands: text or json")
+var versionFormat = flag.String("format", "", "Version format of create: sequential or timestamp")
+var templates = flag.String("templates", "", "Directory of the templates of create")
+var author = flag.String("author", "", "Author passed to the templates of create")
+var methods = flag.String("methods", "", "Go file that create appends method stubs to, for go methods drivers")
+var receiver = flag.String("receiver", "", "Receiver type of the method stubs of create, e.g. *Migrator")
+
+var ctx = context.Background()
 
 func main() {
 	flag.Usage = func() {
@@ -45,13 +65,23 @@ func main() {
 	switch command {
 	case "create":
 		verifyMigrationsPath(*migrationsPath)
+		if *sourceURL != "" {
+			fmt.Println("create writes to -path, it can't be used with -source.")
+			os.Exit(1)
+		}
 		name := flag.Arg(1)
 		if name == "" {
 			fmt.Println("Please specify name.")
 			os.Exit(1)
 		}
 
-		migrationFile, err := migrate.Create(*url, *migrationsPath, name)
+		migrationFile, err := migrate.CreateWithOptions(ctx, *url, *migrationsPath, name, migrate.CreateOptions{
+			VersionFormat:   migrate.VersionFormat(*versionFormat),
+			TemplatesDir:    *templates,
+			Author:          *author,
+			MethodsFile:     *methods,
+			MethodsReceiver: *receiver,
+		})
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -60,6 +90,9 @@ func main() {
 		fmt.Printf("Version %v migration files created in %v:\n", migrationFile.Version, *migrationsPath)
 		fmt.Println(migrationFile.UpFile.FileName)
 		fmt.Println(migrationFile.DownFile.FileName)
+		if *methods != "" {
+			fmt.Printf("Method stubs appended to %v\n", *methods)
+		}
 
 	case "migrate":
 		verifyMigrationsPath(*migrationsPath)
@@ -69,88 +102,164 @@ func main() {
 			fmt.Println("Unable to parse param <n>.")
 			os.Exit(1)
 		}
-		timerStart = time.Now()
-		pipe := pipep.New()
-		go migrate.Migrate(pipe, *url, *migrationsPath, relativeNInt)
-		ok := writePipe(pipe)
-		printTimer()
-		if !ok {
-			os.Exit(1)
-		}
+		runMigrator(func(m *migrate.Migrator, pipe chan interface{}) {
+			m.Steps(ctx, pipe, relativeNInt)
+		})
 
 	case "goto":
 		verifyMigrationsPath(*migrationsPath)
 		toVersion := flag.Arg(1)
-		toVersionInt, err := strconv.Atoi(toVersion)
-		if err != nil || toVersionInt < 0 {
+		toVersionUint, err := strconv.ParseUint(toVersion, 10, 64)
+		if err != nil {
 			fmt.Println("Unable to parse param <v>.")
 			os.Exit(1)
 		}
+		runMigrator(func(m *migrate.Migrator, pipe chan interface{}) {
+			m.Goto(ctx, pipe, toVersionUint)
+		})
+
+	case "up":
+		verifyMigrationsPath(*migrationsPath)
+		runMigrator(func(m *migrate.Migrator, pipe chan interface{}) {
+			m.Up(ctx, pipe)
+		})
 
-		currentVersion, err := migrate.Version(*url, *migrationsPath)
+	case "down":
+		verifyMigrationsPath(*migrationsPath)
+		runMigrator(func(m *migrate.Migrator, pipe chan interface{}) {
+			m.Down(ctx, pipe)
+		})
+
+	case "redo":
+		verifyMigrationsPath(*migrationsPath)
+		runMigrator(func(m *migrate.Migrator, pipe chan interface{}) {
+			m.Redo(ctx, pipe)
+		})
+
+	case "reset":
+		verifyMigrationsPath(*migrationsPath)
+		runMigrator(func(m *migrate.Migrator, pipe chan interface{}) {
+			m.Reset(ctx, pipe)
+		})
+
+	case "plan":
+		verifyMigrationsPath(*migrationsPath)
+		planCmd(flag.Arg(1), flag.Arg(2))
+
+	case "repair":
+		verifyMigrationsPath(*migrationsPath)
+		m, err := newMigrator()
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-
-		relativeNInt := toVersionInt - int(currentVersion)
-
-		timerStart = time.Now()
-		pipe := pipep.New()
-		go migrate.Migrate(pipe, *url, *migrationsPath, relativeNInt)
-		ok := writePipe(pipe)
-		printTimer()
-		if !ok {
+		repaired, err := m.Repair(ctx)
+		for _, mismatch := range repaired {
+			fmt.Printf("Accepted sha256:%s for %s\n", mismatch.Current, mismatch.FileName)
+		}
+		if err == nil && len(repaired) == 0 {
+			fmt.Println("Nothing to repair.")
+		}
+		if err2 := m.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			fmt.Println(err)
 			os.Exit(1)
 		}
 
-	case "up":
+	case "baseline":
 		verifyMigrationsPath(*migrationsPath)
-		timerStart = time.Now()
-		pipe := pipep.New()
-		go migrate.Up(pipe, *url, *migrationsPath)
-		ok := writePipe(pipe)
-		printTimer()
-		if !ok {
+		baselineVersion, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if err != nil {
+			fmt.Println("Unable to parse param <v>.")
 			os.Exit(1)
 		}
-
-	case "down":
-		verifyMigrationsPath(*migrationsPath)
-		timerStart = time.Now()
-		pipe := pipep.New()
-		go migrate.Down(pipe, *url, *migrationsPath)
-		ok := writePipe(pipe)
-		printTimer()
-		if !ok {
+		m, err := newMigrator()
+		if err != nil {
+			fmt.Println(err)
 			os.Exit(1)
 		}
+		err = m.Baseline(ctx, baselineVersion)
+		if err2 := m.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Recorded migrations up to version %v as applied\n", baselineVersion)
 
-	case "redo":
+	case "force":
 		verifyMigrationsPath(*migrationsPath)
-		timerStart = time.Now()
-		pipe := pipep.New()
-		go migrate.Redo(pipe, *url, *migrationsPath)
-		ok := writePipe(pipe)
-		printTimer()
-		if !ok {
+		forceVersion, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if err != nil {
+			fmt.Println("Unable to parse param <v>.")
 			os.Exit(1)
 		}
+		m, err := newMigrator()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		err = m.Force(ctx, forceVersion)
+		if err2 := m.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Forced version %v\n", forceVersion)
 
-	case "reset":
+	case "status":
+		verifyMigrationsPath(*migrationsPath)
+		statusCmd()
+
+	case "history":
 		verifyMigrationsPath(*migrationsPath)
-		timerStart = time.Now()
-		pipe := pipep.New()
-		go migrate.Reset(pipe, *url, *migrationsPath)
-		ok := writePipe(pipe)
-		printTimer()
-		if !ok {
+		historyCmd()
+
+	case "lock-status":
+		verifyMigrationsPath(*migrationsPath)
+		lockStatusCmd()
+
+	case "unlock":
+		verifyMigrationsPath(*migrationsPath)
+		if arg := flag.Arg(1); arg != "-force" && arg != "--force" {
+			fmt.Println("unlock breaks the migration lock, whoever holds it. Check lock-status, then run unlock -force.")
 			os.Exit(1)
 		}
+		m, err := newMigrator()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		err = m.BreakLease(ctx)
+		if err2 := m.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("Broke the migration lock")
+
+	case "pack":
+		verifyMigrationsPath(*migrationsPath)
+		packCmd(flag.Arg(1))
 
 	case "version":
 		verifyMigrationsPath(*migrationsPath)
-		version, err := migrate.Version(*url, *migrationsPath)
+		m, err := newMigrator()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		version, err := m.Version(ctx)
+		if err2 := m.Close(); err == nil {
+			err = err2
+		}
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -164,46 +273,287 @@ func main() {
 	}
 }
 
+// newMigrator connects a Migrator with the options given by flags.
+func newMigrator() (*migrate.Migrator, error) {
+	opts := []migrate.Option{migrate.WithToolVersion(Version), migrate.WithLabel(*label)}
+	if *allowOutOfOrder {
+		opts = append(opts, migrate.AllowOutOfOrder())
+	}
+	if *sourceURL != "" {
+		return migrate.NewSource(*url, *sourceURL, opts...)
+	}
+	return migrate.New(*url, *migrationsPath, opts...)
+}
+
+// runMigrator connects a Migrator, passes it to fn and prints
+// everything fn writes to the pipe. It exits if anything failed.
+func runMigrator(fn func(m *migrate.Migrator, pipe chan interface{})) {
+	m, err := newMigrator()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	timerStart = time.Now()
+	pipe := pipep.New()
+	go fn(m, pipe)
+	ok := writePipe(pipe)
+	printTimer()
+	if err := m.Close(); err != nil {
+		fmt.Println(err)
+		ok = false
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
 func writePipe(pipe chan interface{}) (ok bool) {
 	okFlag := true
-	if pipe != nil {
-		for {
-			select {
-			case item, more := <-pipe:
-				if !more {
-					return okFlag
-				} else {
-					switch item.(type) {
-
-					case string:
-						fmt.Println(item.(string))
-
-					case error:
-						c := color.New(color.FgRed)
-						c.Println(item.(error).Error(), "\n")
-						okFlag = false
-
-					case file.File:
-						f := item.(file.File)
-						c := color.New(color.FgBlue)
-						if f.Direction == direction.Up {
-							c.Print(">")
-						} else if f.Direction == direction.Down {
-							c.Print("<")
-						}
-						fmt.Printf(" %s\n", f.FileName)
-
-					default:
-						text := fmt.Sprint(item)
-						fmt.Println(text)
-					}
-				}
+	for e := range event.FromPipe(pipe) {
+		switch e := e.(type) {
+
+		case event.Error:
+			c := color.New(color.FgRed)
+			c.Printf("%v\n\n", e.Err)
+			okFlag = false
+
+		case event.MigrationStarted:
+			c := color.New(color.FgBlue)
+			if e.File.Direction == direction.Up {
+				c.Print(">")
+			} else if e.File.Direction == direction.Down {
+				c.Print("<")
 			}
+			fmt.Printf(" %s\n", e.File.FileName)
+
+		case event.MigrationFinished:
+			// the total time is printed by printTimer
+
+		default:
+			fmt.Println(e)
 		}
 	}
 	return okFlag
 }
 
+// planCmd prints what command would apply, without applying it.
+func planCmd(command, arg string) {
+	verifyOutput()
+	m, err := newMigrator()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer m.Close()
+
+	var plan *migrate.Plan
+	switch command {
+	case "up":
+		plan, err = m.PlanUp(ctx)
+	case "down":
+		plan, err = m.PlanDown(ctx)
+	case "migrate":
+		relativeN, convErr := strconv.Atoi(arg)
+		if convErr != nil {
+			fmt.Println("Unable to parse param <n>.")
+			os.Exit(1)
+		}
+		plan, err = m.PlanSteps(ctx, relativeN)
+	case "goto":
+		toVersion, convErr := strconv.ParseUint(arg, 10, 64)
+		if convErr != nil {
+			fmt.Println("Unable to parse param <v>.")
+			os.Exit(1)
+		}
+		plan, err = m.PlanGoto(ctx, toVersion)
+	default:
+		fmt.Println("Please specify up, down, migrate <n> or goto <v>.")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *output == "json" {
+		writeJSON(plan)
+		return
+	}
+
+	fmt.Printf("Current version: %v\n", plan.Version)
+	if len(plan.Steps) == 0 {
+		fmt.Println("Nothing to apply.")
+		return
+	}
+	c := color.New(color.FgBlue)
+	for _, step := range plan.Steps {
+		if step.Direction == direction.Up {
+			c.Print(">")
+		} else {
+			c.Print("<")
+		}
+		fmt.Printf(" %s  sha256:%s\n", step.FileName, step.Checksum)
+	}
+}
+
+// statusCmd prints the status of every migration version.
+func statusCmd() {
+	verifyOutput()
+	m, err := newMigrator()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer m.Close()
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *output == "json" {
+		writeJSON(status)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tUP FILE\tDOWN FILE\tAPPLIED AT")
+	for _, s := range status {
+		state := "pending"
+		switch {
+		case s.Dirty:
+			state = "dirty"
+		case s.Missing:
+			state = "applied, missing on disk"
+		case s.Applied:
+			state = "applied"
+		}
+		appliedAt := ""
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", s.Version, s.Name, state, yesNo(s.HasUpFile), yesNo(s.HasDownFile), appliedAt)
+	}
+	w.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// historyCmd prints the history of applied migration files.
+func historyCmd() {
+	verifyOutput()
+	m, err := newMigrator()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	history, err := m.History(ctx)
+	if err2 := m.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *output == "json" {
+		writeJSON(history)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDIRECTION\tNAME\tAPPLIED AT\tDURATION\tHOSTNAME\tTOOL VERSION\tLABEL")
+	for _, e := range history {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			e.Version, e.Direction, e.Name, e.AppliedAt.Local().Format(time.RFC3339), e.Duration, e.Hostname, e.ToolVersion, e.Label)
+	}
+	w.Flush()
+}
+
+// packCmd packs the migration files in -path into the archive filename.
+func packCmd(filename string) {
+	if filename == "" {
+		fmt.Println("Please specify the archive, e.g. migrations.tar.gz or migrations.zip.")
+		os.Exit(1)
+	}
+	format, err := archive.Format(filename)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	manifest, err := archive.Pack(f, format, os.DirFS(*migrationsPath), Version)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(filename)
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Packed %v migration files into %v\n", len(manifest.Files), filename)
+}
+
+// lockStatusCmd prints who holds the migration lock.
+func lockStatusCmd() {
+	verifyOutput()
+	m, err := newMigrator()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer m.Close()
+
+	lease, err := m.Lease(ctx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *output == "json" {
+		writeJSON(lease)
+		return
+	}
+
+	if lease == nil {
+		fmt.Println("The migration lock is not held.")
+		return
+	}
+	expires := "expires"
+	if lease.Expired(time.Now()) {
+		expires = "expired"
+	}
+	fmt.Printf("Held by %s on %s since %s, %s at %s\n", lease.Owner, lease.Hostname,
+		lease.AcquiredAt.Local().Format(time.RFC3339), expires, lease.ExpiresAt.Local().Format(time.RFC3339))
+}
+
+func verifyOutput() {
+	if *output != "text" && *output != "json" {
+		fmt.Println("Unknown output format, use text or json.")
+		os.Exit(1)
+	}
+}
+
+func writeJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
 func verifyMigrationsPath(path string) {
 	if path == "" {
 		fmt.Println("Please specify path")
@@ -224,7 +574,7 @@ func printTimer() {
 
 func helpCmd() {
 	os.Stderr.WriteString(
-		`usage: migrate [-path=<path>] -url=<url> <command> [<args>]
+		`usage: migrate [-path=<path>|-source=<url>] -url=<url> <command> [<args>]
 
 Commands:
    create <name>  Create a new migration
@@ -233,10 +583,53 @@ Commands:
    reset          Down followed by Up
    redo           Roll back most recent migration, then apply it again
    version        Show current migration version
+   status         Show every migration as applied, pending or missing,
+                  -output=text|json
    migrate <n>    Apply migrations -n|+n
    goto <v>       Migrate to version v
+   plan <command> Show what up, down, migrate <n> or goto <v> would apply
+                  without applying it, -output=text|json
+   repair         Accept the checksums of applied migration files that
+                  have been edited
+   baseline <v>   Record migrations up to version v as applied without
+                  applying them, to adopt an existing database
+   force <v>      Set version v and clear the dirty flag without applying
+                  anything, after a failed migration was repaired manually
+   history        Show the history of applied migrations, -output=text|json
+   lock-status    Show who holds the lease of the migration lock,
+                  -output=text|json
+   unlock -force  Break the lease of the migration lock, whoever holds it
+   pack <file>    Pack the migration files in -path into a .tar.gz or .zip
+                  archive with a manifest, apply it with
+                  -source=archive://<file>
    help           Show this help
 
 '-path' defaults to current working directory.
+'-source' reads the migrations from a source url instead of -path, e.g.
+file://./migrations, archive://./migrations.tar.gz, embed://<name> or
+git:///path/to/repo?ref=<ref>&path=<dir> or
+https://host/migrations/manifest.json. The bearer token of https sources
+is read from MIGRATE_SOURCE_TOKEN.
+'-format' is the version format of create, sequential (0001, 0002, ...,
+keeping the zero-padding of existing files) or timestamp (YYYYMMDDHHMMSS).
+It defaults to version_format in migrate.json in -path, or sequential.
+'-templates' is the directory of the text/template templates of create,
+e.g. up.sql.tmpl or postgres.down.tmpl. It defaults to templates_dir in
+migrate.json, or .templates in -path. '-author' is passed to the templates.
+'-methods' is a Go file that create appends stubs of the migration methods
+V<version>_<name>_up and _down to, for the generic and mongodb drivers. It's
+created if it doesn't exist. '-receiver' is their receiver type, e.g.
+*Migrator. It defaults to the receiver of the existing migration methods.
+'-label' is recorded in the migration history, e.g. a git SHA.
+'-allow-out-of-order' applies migrations below the current version that
+haven't been applied yet, instead of failing.
+
+Drivers:
 `)
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 3, ' ', 0)
+	for _, name := range driver.Drivers() {
+		info, _ := driver.GetDriverInfo(name)
+		fmt.Fprintf(w, "   %s\t%s\t%s\n", name, info.Description, info.URLFormat)
+	}
+	w.Flush()
 }
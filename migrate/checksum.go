@@ -0,0 +1,119 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jfrog/go-dbmigrate/driver"
+)
+
+// ChecksumMismatch describes an applied migration file whose content
+// has changed since it was applied.
+type ChecksumMismatch struct {
+	Version  uint64
+	FileName string
+
+	// the checksum stored when the file was applied, empty if the
+	// version was applied before checksums were stored
+	Applied string
+
+	// the checksum of the file as it is now
+	Current string
+}
+
+// ChecksumError is returned if applied migration files have been edited.
+// See Migrator.Repair.
+type ChecksumError struct {
+	Mismatches []ChecksumMismatch
+}
+
+func (e ChecksumError) Error() string {
+	var b strings.Builder
+	b.WriteString("Migration files have been changed after they were applied:\n")
+	for _, mismatch := range e.Mismatches {
+		fmt.Fprintf(&b, "\n  %s\n  - sha256:%s (applied)\n  + sha256:%s (file)\n", mismatch.FileName, mismatch.Applied, mismatch.Current)
+	}
+	b.WriteString("\nRevert the changes, or run repair to accept them.")
+	return b.String()
+}
+
+// VerifyChecksums compares the checksums stored for the applied versions
+// with the checksums of their up migration files and returns ChecksumError
+// if any of them differ. Versions without a stored checksum are skipped.
+// It does nothing for drivers that don't implement driver.ChecksumDriver.
+//
+// All migration functions of Migrator verify the checksums before
+// they apply anything.
+func (m *Migrator) VerifyChecksums(ctx context.Context) error {
+	mismatches, _, err := m.checksumMismatches(ctx)
+	if err != nil {
+		return err
+	}
+	verified := make([]ChecksumMismatch, 0, len(mismatches))
+	for _, mismatch := range mismatches {
+		if mismatch.Applied != "" {
+			verified = append(verified, mismatch)
+		}
+	}
+	if len(verified) > 0 {
+		return ChecksumError{Mismatches: verified}
+	}
+	return nil
+}
+
+// Repair stores the checksums of the current migration files for all
+// applied versions whose stored checksum differs or is missing, which
+// deliberately accepts edited files. It returns what has been repaired.
+func (m *Migrator) Repair(ctx context.Context) (mismatches []ChecksumMismatch, err error) {
+	err = m.withLock(ctx, func(ctx context.Context) error {
+		var ok bool
+		mismatches, ok, err = m.checksumMismatches(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("The driver doesn't store checksums.")
+		}
+		cd := m.driver.(driver.ChecksumDriver)
+		for i, mismatch := range mismatches {
+			if err := cd.SetChecksum(ctx, mismatch.Version, mismatch.Current); err != nil {
+				mismatches = mismatches[:i]
+				return err
+			}
+		}
+		return nil
+	})
+	return mismatches, err
+}
+
+// checksumMismatches returns the applied versions whose stored checksum
+// differs from their up migration file, including missing checksums.
+// ok is false if the driver doesn't store checksums.
+func (m *Migrator) checksumMismatches(ctx context.Context) (mismatches []ChecksumMismatch, ok bool, err error) {
+	checksums, ok, err := driver.Checksums(ctx, m.driver)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	mismatches = make([]ChecksumMismatch, 0)
+	for _, migrationFile := range m.files {
+		applied, isApplied := checksums[migrationFile.Version]
+		if !isApplied || migrationFile.UpFile == nil {
+			continue
+		}
+		current, err := migrationFile.UpFile.Checksum()
+		if err != nil {
+			return nil, true, err
+		}
+		if applied != current {
+			mismatches = append(mismatches, ChecksumMismatch{
+				Version:  migrationFile.Version,
+				FileName: migrationFile.UpFile.FileName,
+				Applied:  applied,
+				Current:  current,
+			})
+		}
+	}
+	return mismatches, true, nil
+}
@@ -0,0 +1,58 @@
+package migrate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	pipep "github.com/jfrog/go-dbmigrate/pipe"
+)
+
+func TestChecksums(t *testing.T) {
+	driverUrl, tmpdir := newSqliteTestDir(t, 2)
+	defer os.RemoveAll(tmpdir)
+
+	m, err := New(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+
+	runSync(t, func(pipe chan interface{}) { m.Steps(ctx, pipe, +1) })
+
+	// edit the applied migration
+	if err := ioutil.WriteFile(path.Join(tmpdir, "0001_migration.up.sql"), []byte("SELECT 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := New(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m2.Close()
+
+	pipe := pipep.New()
+	go m2.Up(ctx, pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	checksumErr, ok := errs[0].(ChecksumError)
+	if !ok || len(checksumErr.Mismatches) != 1 || checksumErr.Mismatches[0].Version != 1 {
+		t.Fatalf("Expected ChecksumError for version 1, got %v", errs[0])
+	}
+	expectVersion(t, m2, 1)
+
+	repaired, err := m2.Repair(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repaired) != 1 || repaired[0].Current != checksumErr.Mismatches[0].Current {
+		t.Fatalf("Expected version 1 to be repaired, got %+v", repaired)
+	}
+
+	runSync(t, func(pipe chan interface{}) { m2.Up(ctx, pipe) })
+	expectVersion(t, m2, 2)
+}
@@ -0,0 +1,46 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// ConfigName is the name of the project config in the migrations path.
+const ConfigName = "migrate.json"
+
+// Config is the project config, the defaults that all developers of a
+// project share. It's read from ConfigName in the migrations path, e.g.
+//
+//	{"version_format": "timestamp", "templates_dir": "../templates"}
+type Config struct {
+	// VersionFormat is the format of the versions of new migration files.
+	// Empty means SequentialVersions.
+	VersionFormat VersionFormat `json:"version_format,omitempty"`
+
+	// TemplatesDir is the directory of the templates of new migration
+	// files, relative to the migrations path. Empty means
+	// DefaultTemplatesDir. See TemplateData.
+	TemplatesDir string `json:"templates_dir,omitempty"`
+}
+
+// ReadConfig reads the project config in migrationsPath. It returns an
+// empty Config if there's none.
+func ReadConfig(migrationsPath string) (*Config, error) {
+	config := &Config{}
+	content, err := ioutil.ReadFile(path.Join(migrationsPath, ConfigName))
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %v", ConfigName, err)
+	}
+	if err := config.VersionFormat.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %v", ConfigName, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,111 @@
+package migrate
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jfrog/go-dbmigrate/file"
+)
+
+// VersionFormat is the format of the versions of new migration files.
+type VersionFormat string
+
+const (
+	// SequentialVersions numbers migration files 1, 2, 3, ... and keeps
+	// the zero-padding of the existing migration files, e.g. 0042.
+	SequentialVersions VersionFormat = "sequential"
+
+	// TimestampVersions numbers migration files by the UTC time of their
+	// creation, e.g. 20240131154500, so that developers on different
+	// branches don't create migration files with the same version.
+	TimestampVersions VersionFormat = "timestamp"
+)
+
+// timestampLayout is the layout of TimestampVersions.
+const timestampLayout = "20060102150405"
+
+// defaultVersionWidth is the zero-padding of sequential versions if there
+// are no migration files yet.
+const defaultVersionWidth = 4
+
+// now is the clock of TimestampVersions.
+var now = time.Now
+
+func (f VersionFormat) validate() error {
+	switch f {
+	case "", SequentialVersions, TimestampVersions:
+		return nil
+	}
+	return fmt.Errorf("Unknown version format %q, use sequential or timestamp", string(f))
+}
+
+// CreateOptions configures CreateWithOptions.
+type CreateOptions struct {
+	// VersionFormat is the format of the version of the new migration
+	// files. Empty means the format of the project config, see Config.
+	VersionFormat VersionFormat
+
+	// TemplatesDir is the directory of the templates of the new migration
+	// files. Empty means the directory of the project config, see Config.
+	TemplatesDir string
+
+	// Author is passed to the templates. Empty means the current user.
+	Author string
+
+	// MethodsFile is the Go file that stubs of the migration methods are
+	// appended to, for drivers that migrate with go methods. It's created
+	// if it doesn't exist. Empty means no stubs.
+	MethodsFile string
+
+	// MethodsReceiver is the type of the receiver of the stubs, e.g.
+	// *Migrator. Empty means the receiver of the existing migration
+	// methods in the package of MethodsFile.
+	MethodsReceiver string
+}
+
+// nextVersion returns the version of a new migration file after files,
+// along with its string in filenames.
+func nextVersion(files file.MigrationFiles, format VersionFormat) (uint64, string) {
+	var last *file.MigrationFile
+	if len(files) > 0 {
+		last = &files[len(files)-1]
+	}
+
+	if format == TimestampVersions {
+		version, _ := strconv.ParseUint(now().UTC().Format(timestampLayout), 10, 64)
+		if last == nil || version > last.Version {
+			return version, strconv.FormatUint(version, 10)
+		}
+		// the clock is behind the last version, keep them in order
+		return last.Version + 1, strconv.FormatUint(last.Version+1, 10)
+	}
+
+	version := uint64(1)
+	width := defaultVersionWidth
+	if last != nil {
+		version = last.Version + 1
+		width = versionWidth(last)
+	}
+	versionStr := strconv.FormatUint(version, 10)
+	if len(versionStr) < width {
+		versionStr = strings.Repeat("0", width-len(versionStr)) + versionStr
+	}
+	return version, versionStr
+}
+
+// versionWidth returns the number of digits of the version in the
+// filenames of migrationFile, including zero-padding.
+func versionWidth(migrationFile *file.MigrationFile) int {
+	f := migrationFile.UpFile
+	if f == nil {
+		f = migrationFile.DownFile
+	}
+	filename := path.Base(f.FileName)
+	if i := strings.Index(filename, "_"); i > 0 {
+		return i
+	}
+	return len(strconv.FormatUint(migrationFile.Version, 10))
+}
@@ -0,0 +1,243 @@
+package migrate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/jfrog/go-dbmigrate/driver/cassandra"
+	_ "github.com/jfrog/go-dbmigrate/driver/generic"
+	"github.com/jfrog/go-dbmigrate/file"
+)
+
+func TestNextVersion(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2024, 1, 31, 15, 45, 0, 0, time.UTC) }
+
+	migrationFiles := func(filenames ...string) file.MigrationFiles {
+		files := make(file.MigrationFiles, 0)
+		for _, filename := range filenames {
+			version, name, d, err := file.ParseFilenameSchema(path.Base(filename), file.FilenameRegex("sql"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, file.MigrationFile{Version: version, UpFile: &file.File{FileName: filename, Version: version, Name: name, Direction: d}})
+		}
+		return files
+	}
+
+	tests := []struct {
+		files      file.MigrationFiles
+		format     VersionFormat
+		version    uint64
+		versionStr string
+	}{
+		{migrationFiles(), "", 1, "0001"},
+		{migrationFiles("001_a.up.sql", "002_b.up.sql"), SequentialVersions, 3, "003"},
+		{migrationFiles("00000041_a.up.sql", "2024/00000042_b.up.sql"), "", 43, "00000043"},
+		{migrationFiles("9_a.up.sql"), "", 10, "10"},
+		{migrationFiles("0042_a.up.sql"), TimestampVersions, 20240131154500, "20240131154500"},
+		{migrationFiles("20240131160000_a.up.sql"), TimestampVersions, 20240131160001, "20240131160001"},
+		{migrationFiles("20240131160000_a.up.sql"), SequentialVersions, 20240131160001, "20240131160001"},
+	}
+	for _, test := range tests {
+		version, versionStr := nextVersion(test.files, test.format)
+		if version != test.version || versionStr != test.versionStr {
+			t.Errorf("Expected %v (%v) after %v in format %q, got %v (%v)", test.version, test.versionStr, test.files, test.format, version, versionStr)
+		}
+	}
+}
+
+func TestCreateWithConfig(t *testing.T) {
+	driverUrl, tmpdir := newSqliteTestDir(t, 0)
+	defer os.RemoveAll(tmpdir)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2024, 1, 31, 15, 45, 0, 0, time.UTC) }
+	ctx := context.Background()
+
+	if err := ioutil.WriteFile(path.Join(tmpdir, ConfigName), []byte(`{"version_format": "timestamp"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	migrationFile, err := CreateWithOptions(ctx, driverUrl, tmpdir, "a", CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrationFile.UpFile.FileName != "20240131154500_a.up.sql" {
+		t.Errorf("Expected a timestamp version from the project config, got %v", migrationFile.UpFile.FileName)
+	}
+
+	migrationFile, err = CreateWithOptions(ctx, driverUrl, tmpdir, "b", CreateOptions{VersionFormat: SequentialVersions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrationFile.UpFile.FileName != "20240131154501_b.up.sql" {
+		t.Errorf("Expected the next sequential version, got %v", migrationFile.UpFile.FileName)
+	}
+
+	if _, err := CreateWithOptions(ctx, driverUrl, tmpdir, "c", CreateOptions{VersionFormat: "date"}); err == nil {
+		t.Error("Expected error for an unknown version format")
+	}
+	if err := ioutil.WriteFile(path.Join(tmpdir, ConfigName), []byte(`{"version_format": "date"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(driverUrl, tmpdir, "c"); err == nil {
+		t.Error("Expected error for an unknown version format in the project config")
+	}
+}
+
+func TestCreateOffline(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-create-offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// the database can't be opened, create must not try
+	migrationFile, err := Create("sqlite3:///nonexistent/dir/db.sqlite", tmpdir, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrationFile.UpFile.FileName != "0001_a.up.sql" || migrationFile.DownFile.FileName != "0001_a.down.sql" {
+		t.Errorf("Expected 0001_a migration files, got %v and %v", migrationFile.UpFile.FileName, migrationFile.DownFile.FileName)
+	}
+	if _, err := Create("unknown://host", tmpdir, "b"); err == nil {
+		t.Error("Expected error for an unknown driver")
+	}
+	if _, err := CreateWithOptions(context.Background(), "cassandra://host/keyspace", tmpdir, "b", CreateOptions{VersionFormat: TimestampVersions}); err == nil {
+		t.Error("Expected error for timestamp versions of a driver that counts versions")
+	}
+}
+
+func TestCreateWithTemplates(t *testing.T) {
+	driverUrl, tmpdir := newSqliteTestDir(t, 0)
+	defer os.RemoveAll(tmpdir)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2024, 1, 31, 15, 45, 0, 0, time.UTC) }
+
+	writeTemplate := func(dir, name, content string) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defaultDir := path.Join(tmpdir, DefaultTemplatesDir)
+	writeTemplate(defaultDir, "up.sql.tmpl", `-- {{.VersionString}} {{.Name}} {{.Direction}} by {{.Author}} on {{.Date.Format "2006-01-02"}}`)
+	writeTemplate(defaultDir, "sqlite3.up.tmpl", `-- {{.Driver}} {{.FilenameExtension}} {{.Version}}`)
+	writeTemplate(defaultDir, "down.sql.tmpl", `-- {{.Name}} {{.Direction}} by {{.Author}} on {{.Date.Format "2006-01-02"}}`)
+	projectDir := path.Join(tmpdir, "..", path.Base(tmpdir)+"-templates")
+	defer os.RemoveAll(projectDir)
+	writeTemplate(projectDir, "down.sql.tmpl", `{{.Unknown}}`)
+
+	tests := []struct {
+		opts   CreateOptions
+		config string
+		up     string
+		down   string
+		err    bool
+	}{
+		{opts: CreateOptions{Author: "alice"}, up: "-- sqlite3 sql 1", down: "-- a down by alice on 2024-01-31"},
+		{opts: CreateOptions{TemplatesDir: projectDir}, err: true},
+		{config: `{"templates_dir": "../` + path.Base(projectDir) + `"}`, err: true},
+		{config: `{"templates_dir": "missing"}`, err: true},
+		{opts: CreateOptions{TemplatesDir: path.Join(tmpdir, "missing")}, err: true},
+	}
+	for i, test := range tests {
+		os.Remove(path.Join(tmpdir, ConfigName))
+		if test.config != "" {
+			if err := ioutil.WriteFile(path.Join(tmpdir, ConfigName), []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		migrationFile, err := CreateWithOptions(context.Background(), driverUrl, tmpdir, "a", test.opts)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error in test %v", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error in test %v: %v", i, err)
+		}
+		for _, f := range []*file.File{migrationFile.UpFile, migrationFile.DownFile} {
+			content, err := ioutil.ReadFile(path.Join(tmpdir, f.FileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := test.up
+			if f == migrationFile.DownFile {
+				expected = test.down
+			}
+			if string(content) != expected {
+				t.Errorf("Expected %q in %v, got %q", expected, f.FileName, content)
+			}
+		}
+	}
+}
+
+func TestCreateWithMethods(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-create-methods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	methodsFile := path.Join(tmpdir, "migrator", "methods.go")
+	if err := os.Mkdir(path.Dir(methodsFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateWithOptions(context.Background(), "generic://", tmpdir, "a", CreateOptions{MethodsFile: methodsFile}); err == nil {
+		t.Error("Expected error without a methods receiver")
+	}
+	if _, err := CreateWithOptions(context.Background(), "sqlite3://", tmpdir, "a", CreateOptions{MethodsFile: methodsFile, MethodsReceiver: "*Migrator"}); err == nil {
+		t.Error("Expected error for a driver without go methods")
+	}
+	if _, err := os.Stat(path.Join(tmpdir, "0001_a.up.gom")); !os.IsNotExist(err) {
+		t.Errorf("Expected no migration files after errors, got %v", err)
+	}
+
+	migrationFile, err := CreateWithOptions(context.Background(), "generic://", tmpdir, "add users", CreateOptions{MethodsFile: methodsFile, MethodsReceiver: "*Migrator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path.Join(tmpdir, migrationFile.UpFile.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "V0001_add_users_up\n" {
+		t.Errorf("Expected the up method in %v, got %q", migrationFile.UpFile.FileName, content)
+	}
+
+	// the receiver is taken from the methods of the first migration
+	if _, err := CreateWithOptions(context.Background(), "generic://", tmpdir, "b", CreateOptions{MethodsFile: methodsFile}); err != nil {
+		t.Fatal(err)
+	}
+	content, err = ioutil.ReadFile(methodsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"V0001_add_users_up", "V0001_add_users_down", "V0002_b_up", "V0002_b_down"} {
+		if !strings.Contains(string(content), "func (r *Migrator) "+method+"() error {") {
+			t.Errorf("Expected a stub of %v, got:\n%s", method, content)
+		}
+	}
+
+	// the migration files can't be written, the Go file must stay as it is
+	if err := os.Mkdir(path.Join(tmpdir, "0003_c.down.gom"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateWithOptions(context.Background(), "generic://", tmpdir, "c", CreateOptions{MethodsFile: methodsFile}); err == nil {
+		t.Error("Expected error if the migration files can't be written")
+	}
+	if after, _ := ioutil.ReadFile(methodsFile); string(after) != string(content) {
+		t.Errorf("Expected no stubs after a failed create, got:\n%s", after)
+	}
+	if _, err := os.Stat(path.Join(tmpdir, "0003_c.up.gom")); !os.IsNotExist(err) {
+		t.Errorf("Expected the up file of a failed create to be removed, got %v", err)
+	}
+}
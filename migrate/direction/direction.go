@@ -1,9 +1,48 @@
 // Package direction just holds convenience constants for Up and Down migrations.
 package direction
 
+import "fmt"
+
 type Direction int
 
 const (
 	Up   Direction = +1
 	Down           = -1
 )
+
+// String returns "up" or "down".
+func (d Direction) String() string {
+	switch d {
+	case Up:
+		return "up"
+	case Down:
+		return "down"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Direction) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Parse returns the Direction for "up" or "down".
+func Parse(s string) (Direction, error) {
+	switch s {
+	case "up":
+		return Up, nil
+	case "down":
+		return Down, nil
+	}
+	return 0, fmt.Errorf("Unknown direction %q", s)
+}
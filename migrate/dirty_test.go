@@ -0,0 +1,109 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/jfrog/go-dbmigrate/driver/sqlite3"
+	"github.com/jfrog/go-dbmigrate/file"
+	pipep "github.com/jfrog/go-dbmigrate/pipe"
+)
+
+// dirtyDriver adds in-memory dirty tracking to the sqlite3 driver,
+// which doesn't need it since its migrations run in a transaction.
+type dirtyDriver struct {
+	*sqlite3.Driver
+	dirty  *file.File
+	forced uint64
+}
+
+func (d *dirtyDriver) SetDirty(ctx context.Context, f file.File) error {
+	f.Content = nil
+	d.dirty = &f
+	return nil
+}
+
+func (d *dirtyDriver) ClearDirty(ctx context.Context) error {
+	d.dirty = nil
+	return nil
+}
+
+func (d *dirtyDriver) Dirty(ctx context.Context) (*file.File, error) {
+	return d.dirty, nil
+}
+
+func (d *dirtyDriver) Force(ctx context.Context, version uint64) error {
+	d.forced = version
+	return d.ClearDirty(ctx)
+}
+
+func TestDirty(t *testing.T) {
+	_, tmpdir := newSqliteTestDir(t, 0)
+	defer os.RemoveAll(tmpdir)
+	writeFile := func(name, content string) {
+		if err := ioutil.WriteFile(path.Join(tmpdir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("0001_a.up.sql", "CREATE TABLE a (id INTEGER);")
+	writeFile("0001_a.down.sql", "DROP TABLE a;")
+	writeFile("0002_b.up.sql", "THIS IS NOT SQL;")
+	writeFile("0002_b.down.sql", "")
+
+	db, err := sql.Open("sqlite3", path.Join(tmpdir, "migrate.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sd, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dirtyDriver{Driver: sd}
+	m, err := NewWithInstance(d, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	pipe := pipep.New()
+	go m.Up(ctx, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) == 0 {
+		t.Fatal("Expected migration 2 to fail")
+	}
+	expectVersion(t, m, 1)
+	if d.dirty == nil || d.dirty.Version != 2 {
+		t.Fatalf("Expected version 2 to be dirty, got %v", d.dirty)
+	}
+
+	pipe = pipep.New()
+	go m.Up(ctx, pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if e, ok := errs[0].(DirtyError); !ok || e.File.FileName != "0002_b.up.sql" {
+		t.Fatalf("Expected DirtyError, got %v", errs[0])
+	}
+
+	if err := m.Force(ctx, 3); err == nil {
+		t.Error("Expected force to a version without migration file to fail")
+	}
+	if err := m.Force(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if d.dirty != nil || d.forced != 1 {
+		t.Fatalf("Expected dirty flag to be cleared by force 1, got %v, %v", d.dirty, d.forced)
+	}
+
+	writeFile("0002_b.up.sql", "CREATE TABLE b (id INTEGER);")
+	runSync(t, func(pipe chan interface{}) { m.Up(ctx, pipe) })
+	expectVersion(t, m, 2)
+	if d.dirty != nil {
+		t.Errorf("Expected dirty flag to be cleared, got %v", d.dirty)
+	}
+}
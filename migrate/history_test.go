@@ -0,0 +1,53 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+func TestHistory(t *testing.T) {
+	driverUrl, tmpdir := newSqliteTestDir(t, 2)
+	defer os.RemoveAll(tmpdir)
+
+	m, err := New(driverUrl, tmpdir, WithToolVersion("1.2.3"), WithLabel("abc123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+
+	runSync(t, func(pipe chan interface{}) { m.Up(ctx, pipe) })
+	runSync(t, func(pipe chan interface{}) { m.Steps(ctx, pipe, -1) })
+
+	history, err := History(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 history entries, got %+v", history)
+	}
+	expect := []struct {
+		version   uint64
+		direction direction.Direction
+	}{
+		{1, direction.Up},
+		{2, direction.Up},
+		{2, direction.Down},
+	}
+	hostname, _ := os.Hostname()
+	for i, e := range expect {
+		entry := history[i]
+		if entry.Version != e.version || entry.Direction != e.direction {
+			t.Errorf("Expected version %v %v, got %+v", e.version, e.direction, entry)
+		}
+		if entry.Name != "migration" || entry.ToolVersion != "1.2.3" || entry.Label != "abc123" || entry.Hostname != hostname {
+			t.Errorf("Unexpected entry %+v", entry)
+		}
+		if entry.AppliedAt.IsZero() {
+			t.Errorf("Expected applied_at to be set, got %+v", entry)
+		}
+	}
+}
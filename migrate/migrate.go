@@ -3,12 +3,13 @@
 package migrate
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	neturl "net/url"
 	"os"
-	"os/signal"
 	"path"
-	"strconv"
 	"strings"
 
 	"github.com/jfrog/go-dbmigrate/driver"
@@ -19,269 +20,392 @@ import (
 
 // Up applies all available migrations
 func Up(pipe chan interface{}, url, migrationsPath string, initOptions ...func(driver.Driver)) {
-	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(url, migrationsPath, initOptions...)
-	if err != nil {
-		go pipep.Close(pipe, err)
-		return
-	}
-
-	applyMigrationFiles, err := files.ToLastFrom(version)
-	if err != nil {
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
-		}
-		go pipep.Close(pipe, err)
-		return
-	}
+	UpContext(context.Background(), pipe, url, migrationsPath, initOptions...)
+}
 
-	if len(applyMigrationFiles) > 0 {
-		for _, f := range applyMigrationFiles {
-			pipe1 := pipep.New()
-			go d.Migrate(f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-				break
-			}
-		}
-		if err := d.Close(); err != nil {
-			pipe <- err
-		}
-		go pipep.Close(pipe, nil)
-		return
-	} else {
-		if err := d.Close(); err != nil {
-			pipe <- err
-		}
-		go pipep.Close(pipe, nil)
-		return
-	}
+// UpContext is like Up, but stops before the next migration file
+// once ctx is done.
+func UpContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, initOptions ...func(driver.Driver)) {
+	runAndClose(ctx, pipe, url, migrationsPath, initOptions, func(ctx context.Context, m *Migrator) {
+		m.up(ctx, pipe)
+	})
 }
 
 // UpSync is synchronous version of Up
 func UpSync(url, migrationsPath string, initOptions ...func(driver.Driver)) (err []error, ok bool) {
+	return UpSyncContext(context.Background(), url, migrationsPath, initOptions...)
+}
+
+// UpSyncContext is synchronous version of UpContext
+func UpSyncContext(ctx context.Context, url, migrationsPath string, initOptions ...func(driver.Driver)) (err []error, ok bool) {
 	pipe := pipep.New()
-	go Up(pipe, url, migrationsPath, initOptions...)
+	go UpContext(ctx, pipe, url, migrationsPath, initOptions...)
 	err = pipep.ReadErrors(pipe)
 	return err, len(err) == 0
 }
 
 // Down rolls back all migrations
 func Down(pipe chan interface{}, url, migrationsPath string, initOptions ...func(driver.Driver)) {
-	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(url, migrationsPath, initOptions...)
-	if err != nil {
-		go pipep.Close(pipe, err)
-		return
-	}
-
-	applyMigrationFiles, err := files.ToFirstFrom(version)
-	if err != nil {
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
-		}
-		go pipep.Close(pipe, err)
-		return
-	}
+	DownContext(context.Background(), pipe, url, migrationsPath, initOptions...)
+}
 
-	if len(applyMigrationFiles) > 0 {
-		for _, f := range applyMigrationFiles {
-			pipe1 := pipep.New()
-			go d.Migrate(f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-				break
-			}
-		}
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
-		}
-		go pipep.Close(pipe, nil)
-		return
-	} else {
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
-		}
-		go pipep.Close(pipe, nil)
-		return
-	}
+// DownContext is like Down, but stops before the next migration file
+// once ctx is done.
+func DownContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, initOptions ...func(driver.Driver)) {
+	runAndClose(ctx, pipe, url, migrationsPath, initOptions, func(ctx context.Context, m *Migrator) {
+		m.down(ctx, pipe)
+	})
 }
 
 // DownSync is synchronous version of Down
 func DownSync(url, migrationsPath string, initOptions ...func(driver.Driver)) (err []error, ok bool) {
+	return DownSyncContext(context.Background(), url, migrationsPath, initOptions...)
+}
+
+// DownSyncContext is synchronous version of DownContext
+func DownSyncContext(ctx context.Context, url, migrationsPath string, initOptions ...func(driver.Driver)) (err []error, ok bool) {
 	pipe := pipep.New()
-	go Down(pipe, url, migrationsPath, initOptions...)
+	go DownContext(ctx, pipe, url, migrationsPath, initOptions...)
 	err = pipep.ReadErrors(pipe)
 	return err, len(err) == 0
 }
 
 // Redo rolls back the most recently applied migration, then runs it again.
 func Redo(pipe chan interface{}, url, migrationsPath string, initOptions ...func(driver.Driver)) {
-	pipe1 := pipep.New()
-	go Migrate(pipe1, url, migrationsPath, -1, initOptions...)
-	if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-		go pipep.Close(pipe, nil)
-		return
-	} else {
-		go Migrate(pipe, url, migrationsPath, +1, initOptions...)
-	}
+	RedoContext(context.Background(), pipe, url, migrationsPath, initOptions...)
+}
+
+// RedoContext is like Redo, but doesn't run the migration again
+// once ctx is done.
+func RedoContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, initOptions ...func(driver.Driver)) {
+	runAndClose(ctx, pipe, url, migrationsPath, initOptions, func(ctx context.Context, m *Migrator) {
+		m.redo(ctx, pipe)
+	})
 }
 
 // RedoSync is synchronous version of Redo
 func RedoSync(url, migrationsPath string, initOptions ...func(driver.Driver)) (err []error, ok bool) {
+	return RedoSyncContext(context.Background(), url, migrationsPath, initOptions...)
+}
+
+// RedoSyncContext is synchronous version of RedoContext
+func RedoSyncContext(ctx context.Context, url, migrationsPath string, initOptions ...func(driver.Driver)) (err []error, ok bool) {
 	pipe := pipep.New()
-	go Redo(pipe, url, migrationsPath, initOptions...)
+	go RedoContext(ctx, pipe, url, migrationsPath, initOptions...)
 	err = pipep.ReadErrors(pipe)
 	return err, len(err) == 0
 }
 
 // Reset runs the down and up migration function
 func Reset(pipe chan interface{}, url, migrationsPath string, initOptions ...func(driver.Driver)) {
-	pipe1 := pipep.New()
-	go Down(pipe1, url, migrationsPath)
-	if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-		go pipep.Close(pipe, nil)
-		return
-	} else {
-		go Up(pipe, url, migrationsPath, initOptions...)
-	}
+	ResetContext(context.Background(), pipe, url, migrationsPath, initOptions...)
+}
+
+// ResetContext is like Reset, but doesn't run the up migrations
+// once ctx is done.
+func ResetContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, initOptions ...func(driver.Driver)) {
+	runAndClose(ctx, pipe, url, migrationsPath, initOptions, func(ctx context.Context, m *Migrator) {
+		m.reset(ctx, pipe)
+	})
 }
 
 // ResetSync is synchronous version of Reset
 func ResetSync(url, migrationsPath string, initOptions ...func(driver.Driver)) (err []error, ok bool) {
+	return ResetSyncContext(context.Background(), url, migrationsPath, initOptions...)
+}
+
+// ResetSyncContext is synchronous version of ResetContext
+func ResetSyncContext(ctx context.Context, url, migrationsPath string, initOptions ...func(driver.Driver)) (err []error, ok bool) {
 	pipe := pipep.New()
-	go Reset(pipe, url, migrationsPath, initOptions...)
+	go ResetContext(ctx, pipe, url, migrationsPath, initOptions...)
 	err = pipep.ReadErrors(pipe)
 	return err, len(err) == 0
 }
 
 // Migrate applies relative +n/-n migrations
 func Migrate(pipe chan interface{}, url, migrationsPath string, relativeN int, initOptions ...func(driver.Driver)) {
-	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(url, migrationsPath, initOptions...)
-	if err != nil {
-		go pipep.Close(pipe, err)
-		return
-	}
-
-	applyMigrationFiles, err := files.From(version, relativeN)
-	if err != nil {
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
-		}
-		go pipep.Close(pipe, err)
-		return
-	}
+	MigrateContext(context.Background(), pipe, url, migrationsPath, relativeN, initOptions...)
+}
 
-	if len(applyMigrationFiles) > 0 && relativeN != 0 {
-		for _, f := range applyMigrationFiles {
-			pipe1 := pipep.New()
-			go d.Migrate(f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-				break
-			}
-		}
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
-		}
-		go pipep.Close(pipe, nil)
-		return
-	}
-	if err2 := d.Close(); err2 != nil {
-		pipe <- err2
-	}
-	go pipep.Close(pipe, nil)
-	return
+// MigrateContext is like Migrate, but stops before the next migration file
+// once ctx is done.
+func MigrateContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, relativeN int, initOptions ...func(driver.Driver)) {
+	runAndClose(ctx, pipe, url, migrationsPath, initOptions, func(ctx context.Context, m *Migrator) {
+		m.steps(ctx, pipe, relativeN)
+	})
 }
 
 // MigrateSync is synchronous version of Migrate
 func MigrateSync(url, migrationsPath string, relativeN int, initOptions ...func(driver.Driver)) (err []error, ok bool) {
+	return MigrateSyncContext(context.Background(), url, migrationsPath, relativeN, initOptions...)
+}
+
+// MigrateSyncContext is synchronous version of MigrateContext
+func MigrateSyncContext(ctx context.Context, url, migrationsPath string, relativeN int, initOptions ...func(driver.Driver)) (err []error, ok bool) {
 	pipe := pipep.New()
-	go Migrate(pipe, url, migrationsPath, relativeN, initOptions...)
+	go MigrateContext(ctx, pipe, url, migrationsPath, relativeN, initOptions...)
+	err = pipep.ReadErrors(pipe)
+	return err, len(err) == 0
+}
+
+// Goto migrates up or down to exactly the given version, no matter
+// if there are gaps in the version numbers. It fails if there is no
+// migration file for version, unless it's 0.
+func Goto(pipe chan interface{}, url, migrationsPath string, version uint64, initOptions ...func(driver.Driver)) {
+	GotoContext(context.Background(), pipe, url, migrationsPath, version, initOptions...)
+}
+
+// GotoContext is like Goto, but stops before the next migration file
+// once ctx is done.
+func GotoContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, version uint64, initOptions ...func(driver.Driver)) {
+	runAndClose(ctx, pipe, url, migrationsPath, initOptions, func(ctx context.Context, m *Migrator) {
+		m.gotoVersion(ctx, pipe, version)
+	})
+}
+
+// GotoSync is synchronous version of Goto
+func GotoSync(url, migrationsPath string, version uint64, initOptions ...func(driver.Driver)) (err []error, ok bool) {
+	return GotoSyncContext(context.Background(), url, migrationsPath, version, initOptions...)
+}
+
+// GotoSyncContext is synchronous version of GotoContext
+func GotoSyncContext(ctx context.Context, url, migrationsPath string, version uint64, initOptions ...func(driver.Driver)) (err []error, ok bool) {
+	pipe := pipep.New()
+	go GotoContext(ctx, pipe, url, migrationsPath, version, initOptions...)
 	err = pipep.ReadErrors(pipe)
 	return err, len(err) == 0
 }
 
 // Version returns the current migration version
 func Version(url, migrationsPath string, initOptions ...func(driver.Driver)) (version uint64, err error) {
-	d, err := driver.New(url, initOptions...)
+	return VersionContext(context.Background(), url, migrationsPath, initOptions...)
+}
+
+// VersionContext is like Version.
+func VersionContext(ctx context.Context, url, migrationsPath string, initOptions ...func(driver.Driver)) (version uint64, err error) {
+	d, err := driver.NewContext(ctx, url, initOptions...)
 	if err != nil {
 		return 0, err
 	}
 	defer func() {
-		err = d.Close()
+		if err2 := d.Close(); err == nil {
+			err = err2
+		}
 	}()
-	return d.Version()
+	return driver.VersionContext(ctx, d)
 }
 
-// Create creates new migration files on disk
-func Create(url, migrationsPath, name string, initOptions ...func(driver.Driver)) (*file.MigrationFile, error) {
-	d, err := driver.New(url, initOptions...)
+// Baseline records all migrations up to and including version as applied,
+// without applying them. See Migrator.Baseline.
+func Baseline(url, migrationsPath string, version uint64, initOptions ...func(driver.Driver)) error {
+	return BaselineContext(context.Background(), url, migrationsPath, version, initOptions...)
+}
+
+// BaselineContext is like Baseline.
+func BaselineContext(ctx context.Context, url, migrationsPath string, version uint64, initOptions ...func(driver.Driver)) (err error) {
+	m, err := NewContext(ctx, url, migrationsPath, WithDriverOptions(initOptions...))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := m.Close(); err == nil {
+			err = err2
+		}
+	}()
+	return m.Baseline(ctx, version)
+}
+
+// Status returns the status of every migration version.
+// See Migrator.Status.
+func Status(url, migrationsPath string, initOptions ...func(driver.Driver)) ([]MigrationStatus, error) {
+	return StatusContext(context.Background(), url, migrationsPath, initOptions...)
+}
+
+// StatusContext is like Status.
+func StatusContext(ctx context.Context, url, migrationsPath string, initOptions ...func(driver.Driver)) (status []MigrationStatus, err error) {
+	m, err := NewContext(ctx, url, migrationsPath, WithDriverOptions(initOptions...))
 	if err != nil {
 		return nil, err
 	}
-	files, err := file.ReadMigrationFiles(migrationsPath, file.FilenameRegex(d.FilenameExtension()))
+	defer func() {
+		if err2 := m.Close(); err == nil {
+			err = err2
+		}
+	}()
+	return m.Status(ctx)
+}
+
+// History returns the history of applied migration files, oldest first.
+// It fails if the driver doesn't implement driver.HistoryDriver.
+func History(url, migrationsPath string, initOptions ...func(driver.Driver)) ([]driver.HistoryEntry, error) {
+	return HistoryContext(context.Background(), url, migrationsPath, initOptions...)
+}
+
+// HistoryContext is like History.
+func HistoryContext(ctx context.Context, url, migrationsPath string, initOptions ...func(driver.Driver)) (history []driver.HistoryEntry, err error) {
+	d, err := driver.NewContext(ctx, url, initOptions...)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err2 := d.Close(); err == nil {
+			err = err2
+		}
+	}()
+	hd, ok := d.(driver.HistoryDriver)
+	if !ok {
+		return nil, errors.New("The driver doesn't keep a history.")
+	}
+	return hd.History(ctx)
+}
 
-	version := uint64(0)
-	if len(files) > 0 {
-		lastFile := files[len(files)-1]
-		version = lastFile.Version
+// Create creates new migration files on disk. It only needs the scheme of
+// url to find the filename extension of the driver, it doesn't connect to
+// the database. initOptions are ignored.
+func Create(url, migrationsPath, name string, initOptions ...func(driver.Driver)) (*file.MigrationFile, error) {
+	return CreateContext(context.Background(), url, migrationsPath, name)
+}
+
+// CreateContext is like Create.
+func CreateContext(ctx context.Context, url, migrationsPath, name string) (*file.MigrationFile, error) {
+	return CreateWithOptions(ctx, url, migrationsPath, name, CreateOptions{})
+}
+
+// CreateWithOptions is like Create, with the options that Create takes
+// from the project config.
+func CreateWithOptions(ctx context.Context, url, migrationsPath, name string, opts CreateOptions) (*file.MigrationFile, error) {
+	if err := opts.VersionFormat.validate(); err != nil {
+		return nil, err
+	}
+	config, err := ReadConfig(migrationsPath)
+	if err != nil {
+		return nil, err
+	}
+	format := opts.VersionFormat
+	if format == "" {
+		format = config.VersionFormat
+	}
+	templates, err := templatesDir(migrationsPath, opts, config)
+	if err != nil {
+		return nil, err
 	}
-	version += 1
-	versionStr := strconv.FormatUint(version, 10)
 
-	length := 4 // TODO(mattes) check existing files and try to guess length
-	if len(versionStr)%length != 0 {
-		versionStr = strings.Repeat("0", length-len(versionStr)%length) + versionStr
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	info, err := driver.InfoFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if format == TimestampVersions && info.SequentialVersionsOnly {
+		return nil, fmt.Errorf("Driver '%s' can't store timestamp versions, use sequential versions.", u.Scheme)
+	}
+	files, err := file.ReadMigrationFiles(migrationsPath, file.FilenameRegex(info.FilenameExtension))
+	if err != nil {
+		return nil, err
 	}
+	version, versionStr := nextVersion(files, format)
 
 	filenamef := "%s_%s.%s.%s"
 	name = strings.Replace(name, " ", "_", -1)
+	author := opts.Author
+	if author == "" {
+		author = currentAuthor()
+	}
 
-	mfile := &file.MigrationFile{
-		Version: version,
-		UpFile: &file.File{
-			Path:      migrationsPath,
-			FileName:  fmt.Sprintf(filenamef, versionStr, name, "up", d.FilenameExtension()),
-			Name:      name,
-			Content:   []byte(""),
-			Direction: direction.Up,
-		},
-		DownFile: &file.File{
+	var methods map[direction.Direction][]string
+	var stubs []string
+	if opts.MethodsFile != "" {
+		if info.Stubs == nil {
+			return nil, fmt.Errorf("Driver '%s' doesn't migrate with go methods.", u.Scheme)
+		}
+		methods = make(map[direction.Direction][]string)
+		for _, d := range []direction.Direction{direction.Up, direction.Down} {
+			method, err := info.Stubs.MethodName(versionStr, name, d)
+			if err != nil {
+				return nil, err
+			}
+			methods[d] = []string{method}
+			stubs = append(stubs, method)
+		}
+	}
+
+	created := now().UTC()
+	mfile := &file.MigrationFile{Version: version}
+	for _, d := range []direction.Direction{direction.Up, direction.Down} {
+		content, err := executeTemplate(templates, TemplateData{
+			Version:           version,
+			VersionString:     versionStr,
+			Name:              name,
+			Direction:         d,
+			Driver:            u.Scheme,
+			FilenameExtension: info.FilenameExtension,
+			Author:            author,
+			Date:              created,
+			Methods:           methods[d],
+		})
+		if err != nil {
+			return nil, err
+		}
+		f := &file.File{
 			Path:      migrationsPath,
-			FileName:  fmt.Sprintf(filenamef, versionStr, name, "down", d.FilenameExtension()),
+			FileName:  fmt.Sprintf(filenamef, versionStr, name, d, info.FilenameExtension),
 			Name:      name,
-			Content:   []byte(""),
-			Direction: direction.Down,
-		},
+			Content:   content,
+			Direction: d,
+		}
+		if d == direction.Up {
+			mfile.UpFile = f
+		} else {
+			mfile.DownFile = f
+		}
 	}
 
-	if err := ioutil.WriteFile(path.Join(mfile.UpFile.Path, mfile.UpFile.FileName), mfile.UpFile.Content, 0644); err != nil {
+	upPath := path.Join(mfile.UpFile.Path, mfile.UpFile.FileName)
+	downPath := path.Join(mfile.DownFile.Path, mfile.DownFile.FileName)
+	if err := ioutil.WriteFile(upPath, mfile.UpFile.Content, 0644); err != nil {
 		return nil, err
 	}
-	if err := ioutil.WriteFile(path.Join(mfile.DownFile.Path, mfile.DownFile.FileName), mfile.DownFile.Content, 0644); err != nil {
+	if err := ioutil.WriteFile(downPath, mfile.DownFile.Content, 0644); err != nil {
+		os.Remove(upPath)
 		return nil, err
 	}
 
+	// the Go file is changed last, so it never gets stubs of a migration
+	// whose files couldn't be written
+	if stubs != nil {
+		if err := info.Stubs.AppendStubs(opts.MethodsFile, opts.MethodsReceiver, stubs); err != nil {
+			os.Remove(upPath)
+			os.Remove(downPath)
+			return nil, err
+		}
+	}
+
 	return mfile, nil
 }
 
-// initDriverAndReadMigrationFilesAndGetVersion is a small helper
-// function that is common to most of the migration funcs
-func initDriverAndReadMigrationFilesAndGetVersion(url, migrationsPath string, initOptions ...func(driver.Driver)) (driver.Driver, *file.MigrationFiles, uint64, error) {
-	d, err := driver.New(url, initOptions...)
+// runAndClose is a small helper function that is common to the
+// package level migration funcs. It creates a Migrator, passes it to fn
+// along with the context of the migration lock, see Migrator.withLock,
+// and closes the Migrator and the pipe afterwards.
+func runAndClose(ctx context.Context, pipe chan interface{}, url, migrationsPath string, initOptions []func(driver.Driver), fn func(ctx context.Context, m *Migrator)) {
+	m, err := NewContext(ctx, url, migrationsPath, WithDriverOptions(initOptions...))
 	if err != nil {
-		return nil, nil, 0, err
-	}
-	files, err := file.ReadMigrationFiles(migrationsPath, file.FilenameRegex(d.FilenameExtension()))
-	if err != nil {
-		d.Close() // TODO what happens with errors from this func?
-		return nil, nil, 0, err
+		go pipep.Close(pipe, err)
+		return
 	}
-	version, err := d.Version()
-	if err != nil {
-		d.Close() // TODO what happens with errors from this func?
-		return nil, nil, 0, err
+	m.locked(ctx, pipe, func(ctx context.Context) bool {
+		fn(ctx, m)
+		return true
+	})
+	if err := m.Close(); err != nil {
+		pipe <- err
 	}
-	return d, &files, version, nil
+	go pipep.Close(pipe, nil)
 }
 
 // NewPipe is a convenience function for pipe.New().
@@ -290,8 +414,8 @@ func NewPipe() chan interface{} {
 	return pipep.New()
 }
 
-// interrupts is an internal variable that holds the state of
-// interrupt handling
+// interrupts is an internal variable that holds the default state
+// of interrupt handling for new Migrators
 var interrupts = true
 
 // Graceful enables interrupts checking. Once the first ^C is received
@@ -307,14 +431,3 @@ func Graceful() {
 func NonGraceful() {
 	interrupts = false
 }
-
-// interrupts returns a signal channel if interrupts checking is
-// enabled. nil otherwise.
-func handleInterrupts() chan os.Signal {
-	if interrupts {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		return c
-	}
-	return nil
-}
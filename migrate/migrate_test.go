@@ -1,11 +1,15 @@
 package migrate
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
-	// Ensure imports for each driver we wish to test
 
+	"github.com/jfrog/go-dbmigrate/driver"
+
+	// Ensure imports for each driver we wish to test
 	_ "github.com/jfrog/go-dbmigrate/driver/postgres"
 	_ "github.com/jfrog/go-dbmigrate/driver/sqlite3"
 )
@@ -240,3 +244,122 @@ func TestMigrate(t *testing.T) {
 		}
 	}
 }
+
+func TestUpContextCanceled(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	driverUrl := "sqlite3://" + path.Join(tmpdir, "migrate.db")
+
+	Create(driverUrl, tmpdir, "migration1")
+	Create(driverUrl, tmpdir, "migration2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs, ok := UpSyncContext(ctx, driverUrl, tmpdir)
+	if ok {
+		t.Fatal("Expected canceled context to abort migration")
+	}
+	if len(errs) != 1 || errs[0] != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", errs)
+	}
+
+	version, err := Version(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("Expected version 0, got %v", version)
+	}
+
+	errs, ok = UpSyncContext(context.Background(), driverUrl, tmpdir)
+	if !ok {
+		t.Fatal(errs)
+	}
+	version, err = Version(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Fatalf("Expected version 2, got %v", version)
+	}
+}
+
+func TestGotoWithGaps(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	driverUrl := "sqlite3://" + path.Join(tmpdir, "migrate.db")
+
+	for _, name := range []string{
+		"0003_a.up.sql", "0003_a.down.sql",
+		"0010_b.up.sql", "0010_b.down.sql",
+		"0042_c.up.sql", "0042_c.down.sql",
+	} {
+		if err := ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		to        uint64
+		expectErr bool
+		expect    uint64
+	}{
+		{10, false, 10},
+		{42, false, 42},
+		{3, false, 3},
+		{5, true, 3},
+		{0, false, 0},
+	} {
+		errs, ok := GotoSync(driverUrl, tmpdir, test.to)
+		if ok == test.expectErr {
+			t.Errorf("goto %v: unexpected result %v", test.to, errs)
+		}
+		version, err := Version(driverUrl, tmpdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != test.expect {
+			t.Errorf("goto %v: expected version %v, got %v", test.to, test.expect, version)
+		}
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	driverUrl, tmpdir := newSqliteTestDir(t, 3)
+	defer os.RemoveAll(tmpdir)
+
+	if err := Baseline(driverUrl, tmpdir, 4); err == nil {
+		t.Error("Expected baseline to a version without migration file to fail")
+	}
+	if err := Baseline(driverUrl, tmpdir, 2); err != nil {
+		t.Fatal(err)
+	}
+	version, err := Version(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Fatalf("Expected version 2, got %v", version)
+	}
+	if err := Baseline(driverUrl, tmpdir, 3); err != driver.ErrAlreadyMigrated {
+		t.Fatalf("Expected ErrAlreadyMigrated, got %v", err)
+	}
+
+	// baselined versions are neither out of order nor edited
+	if errs, ok := UpSync(driverUrl, tmpdir); !ok {
+		t.Fatal(errs)
+	}
+	version, err = Version(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 3 {
+		t.Fatalf("Expected version 3, got %v", version)
+	}
+}
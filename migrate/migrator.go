@@ -0,0 +1,771 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/jfrog/go-dbmigrate/driver"
+	"github.com/jfrog/go-dbmigrate/event"
+	"github.com/jfrog/go-dbmigrate/file"
+	pipep "github.com/jfrog/go-dbmigrate/pipe"
+	"github.com/jfrog/go-dbmigrate/source"
+)
+
+// Migrator applies migrations from one source through a single
+// driver connection. Unlike the package level functions, it doesn't
+// connect again for every call, so it's the better choice when several
+// migration functions are called in a row.
+//
+// All migration methods of Migrator write to the given pipe and close it
+// when they are done. They must not be called concurrently.
+type Migrator struct {
+	driver driver.Driver
+	source source.Source
+	files  file.MigrationFiles
+
+	// opens the source for the filename extension of the driver
+	openSource func(filenameExtension string) (source.Source, error)
+
+	graceful        bool
+	allowOutOfOrder bool
+	lockTimeout     time.Duration
+	observers       []event.Observer
+	initOptions     []func(driver.Driver)
+
+	// recorded in the history, see driver.HistoryEntry
+	hostname    string
+	toolVersion string
+	label       string
+}
+
+// OutOfOrderError is returned if there are migration files below the
+// current version that haven't been applied, e.g. because they were
+// merged from a branch after newer migrations had been applied.
+// See AllowOutOfOrder.
+type OutOfOrderError struct {
+	// the current version
+	Version uint64
+
+	// the unapplied up migration files, in ascending order
+	Files file.Files
+}
+
+func (e OutOfOrderError) Error() string {
+	names := make([]string, 0, len(e.Files))
+	for _, f := range e.Files {
+		names = append(names, f.FileName)
+	}
+	return fmt.Sprintf("Migrations below the current version %v have not been applied: %s. Allow out of order migrations to apply them.", e.Version, strings.Join(names, ", "))
+}
+
+// DirtyError is returned if a migration file failed halfway with a driver
+// implementing driver.DirtyDriver, so the database may be partially
+// migrated. See Migrator.Force.
+type DirtyError struct {
+	// the migration file that failed, without its content
+	File file.File
+}
+
+func (e DirtyError) Error() string {
+	return fmt.Sprintf("The database is dirty: %s failed halfway and may be partially applied. Repair the database manually, then force the version it's at.", e.File.FileName)
+}
+
+// DefaultLockTimeout is how long a Migrator waits for the migration lock
+// by default. See WithLockTimeout.
+const DefaultLockTimeout = 15 * time.Second
+
+// Option configures a Migrator.
+type Option func(*Migrator)
+
+// WithGraceful enables or disables interrupts checking for this Migrator.
+// See Graceful and NonGraceful.
+func WithGraceful(graceful bool) Option {
+	return func(m *Migrator) {
+		m.graceful = graceful
+	}
+}
+
+// AllowOutOfOrder applies migrations below the current version that
+// haven't been applied yet, instead of failing with OutOfOrderError.
+// Up applies them before any newer migration. This only has an effect
+// with drivers implementing driver.AppliedVersionsDriver.
+func AllowOutOfOrder() Option {
+	return func(m *Migrator) {
+		m.allowOutOfOrder = true
+	}
+}
+
+// WithLockTimeout sets how long to wait for the migration lock of drivers
+// implementing driver.Locker before failing with driver.ErrLocked.
+// A timeout of 0 waits until the context is done.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockTimeout = timeout
+	}
+}
+
+// WithToolVersion records v as the version of the migrate tool in the
+// history of drivers implementing driver.HistoryDriver.
+func WithToolVersion(v string) Option {
+	return func(m *Migrator) {
+		m.toolVersion = v
+	}
+}
+
+// WithLabel records label, e.g. a git SHA, in the history of drivers
+// implementing driver.HistoryDriver.
+func WithLabel(label string) Option {
+	return func(m *Migrator) {
+		m.label = label
+	}
+}
+
+// WithObserver passes the events of all migrations to o,
+// in addition to writing them to the pipe.
+func WithObserver(o event.Observer) Option {
+	return func(m *Migrator) {
+		m.observers = append(m.observers, o)
+	}
+}
+
+// WithLogger prints the events of all migrations to l.
+func WithLogger(l *log.Logger) Option {
+	return WithObserver(event.ObserverFunc(func(e event.Event) {
+		l.Println(e)
+	}))
+}
+
+// WithDriverOptions passes initOptions to the driver when it's initialized.
+func WithDriverOptions(initOptions ...func(driver.Driver)) Option {
+	return func(m *Migrator) {
+		m.initOptions = append(m.initOptions, initOptions...)
+	}
+}
+
+// New connects to url and reads the migration files in migrationsPath.
+// The returned Migrator must be closed when it's no longer needed.
+func New(url, migrationsPath string, opts ...Option) (*Migrator, error) {
+	return NewContext(context.Background(), url, migrationsPath, opts...)
+}
+
+// NewContext is like New.
+func NewContext(ctx context.Context, url, migrationsPath string, opts ...Option) (*Migrator, error) {
+	m := newMigrator(opts)
+	m.openSource = func(filenameExtension string) (source.Source, error) {
+		return source.NewDir(migrationsPath, filenameExtension)
+	}
+	if err := m.connect(ctx, url); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewFS is like New, but reads the migration files from the root directory
+// of fsys, e.g. an embed.FS. Use fs.Sub for migration files in a
+// subdirectory.
+func NewFS(url string, fsys fs.FS, opts ...Option) (*Migrator, error) {
+	return NewFSContext(context.Background(), url, fsys, opts...)
+}
+
+// NewFSContext is like NewFS.
+func NewFSContext(ctx context.Context, url string, fsys fs.FS, opts ...Option) (*Migrator, error) {
+	m := newMigrator(opts)
+	m.openSource = func(filenameExtension string) (source.Source, error) {
+		return source.NewFS(fsys, filenameExtension)
+	}
+	if err := m.connect(ctx, url); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewSource is like New, but reads the migration files from the source at
+// sourceURL, e.g. file://./migrations, see source.Open.
+func NewSource(url, sourceURL string, opts ...Option) (*Migrator, error) {
+	return NewSourceContext(context.Background(), url, sourceURL, opts...)
+}
+
+// NewSourceContext is like NewSource.
+func NewSourceContext(ctx context.Context, url, sourceURL string, opts ...Option) (*Migrator, error) {
+	m := newMigrator(opts)
+	m.openSource = func(filenameExtension string) (source.Source, error) {
+		return source.Open(sourceURL, filenameExtension)
+	}
+	if err := m.connect(ctx, url); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewWithInstance returns a Migrator that applies the migration files in
+// migrationsPath through d, a driver that has been set up by the caller,
+// e.g. with postgres.WithInstance. WithDriverOptions has no effect here.
+// Closing the Migrator closes d.
+func NewWithInstance(d driver.Driver, migrationsPath string, opts ...Option) (*Migrator, error) {
+	m := newMigrator(opts)
+	m.openSource = func(filenameExtension string) (source.Source, error) {
+		return source.NewDir(migrationsPath, filenameExtension)
+	}
+	if err := m.readMigrationFiles(d); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewWithInstanceFS is like NewWithInstance, but reads the migration files
+// from the root directory of fsys, like NewFS.
+func NewWithInstanceFS(d driver.Driver, fsys fs.FS, opts ...Option) (*Migrator, error) {
+	m := newMigrator(opts)
+	m.openSource = func(filenameExtension string) (source.Source, error) {
+		return source.NewFS(fsys, filenameExtension)
+	}
+	if err := m.readMigrationFiles(d); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newMigrator(opts []Option) *Migrator {
+	hostname, _ := os.Hostname()
+	m := &Migrator{
+		graceful:    interrupts,
+		lockTimeout: DefaultLockTimeout,
+		hostname:    hostname,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// connect connects to url and reads the migration files.
+func (m *Migrator) connect(ctx context.Context, url string) error {
+	d, err := driver.NewContext(ctx, url, m.initOptions...)
+	if err != nil {
+		return err
+	}
+	if err := m.readMigrationFiles(d); err != nil {
+		d.Close() // TODO what happens with errors from this func?
+		return err
+	}
+	return nil
+}
+
+// readMigrationFiles opens the source for d and reads its migration files.
+// Their content is read from the source when they are applied.
+func (m *Migrator) readMigrationFiles(d driver.Driver) error {
+	src, err := m.openSource(d.FilenameExtension())
+	if err != nil {
+		return err
+	}
+	fsys, err := source.FS(src)
+	if err != nil {
+		src.Close()
+		return err
+	}
+	files, err := file.ReadMigrationFilesFS(fsys, file.FilenameRegex(d.FilenameExtension()))
+	if err != nil {
+		src.Close()
+		return err
+	}
+	m.driver = d
+	m.source = src
+	m.files = files
+	return nil
+}
+
+// Close closes the driver connection and the source.
+func (m *Migrator) Close() error {
+	err := m.driver.Close()
+	if err2 := m.source.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Version returns the current migration version.
+func (m *Migrator) Version(ctx context.Context) (uint64, error) {
+	return driver.VersionContext(ctx, m.driver)
+}
+
+// History returns the history of applied migration files, oldest first.
+// It fails if the driver doesn't implement driver.HistoryDriver.
+func (m *Migrator) History(ctx context.Context) ([]driver.HistoryEntry, error) {
+	hd, ok := m.driver.(driver.HistoryDriver)
+	if !ok {
+		return nil, errors.New("The driver doesn't keep a history.")
+	}
+	return hd.History(ctx)
+}
+
+// Baseline records all migrations up to and including version as applied,
+// without applying them, to adopt a database whose schema has been created
+// by other means. version must be the version of a migration file. It fails
+// if any migration has been applied before, or if the driver doesn't
+// implement driver.BaselineDriver.
+func (m *Migrator) Baseline(ctx context.Context, version uint64) error {
+	bd, ok := m.driver.(driver.BaselineDriver)
+	if !ok {
+		return errors.New("The driver doesn't support baselines.")
+	}
+	if version == 0 {
+		return errors.New("Baseline version must not be 0.")
+	}
+	files, err := m.files.ToVersion(0, version)
+	if err != nil {
+		return err
+	}
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return bd.Baseline(ctx, files)
+	})
+}
+
+// Force sets the current version to version and clears the dirty flag,
+// without applying anything. Use it once a database that has been left
+// dirty by a failed migration has been repaired manually. version must
+// be 0 or the version of a migration file. It fails if the driver doesn't
+// implement driver.DirtyDriver.
+func (m *Migrator) Force(ctx context.Context, version uint64) error {
+	dd, ok := m.driver.(driver.DirtyDriver)
+	if !ok {
+		return errors.New("The driver doesn't track dirty migrations.")
+	}
+	var upFile *file.File
+	if version > 0 {
+		for _, migrationFile := range m.files {
+			if migrationFile.Version == version {
+				upFile = migrationFile.UpFile
+			}
+		}
+		if upFile == nil {
+			return fmt.Errorf("No up migration file for version %v", version)
+		}
+	}
+	return m.withLock(ctx, func(ctx context.Context) error {
+		if err := dd.Force(ctx, version); err != nil {
+			return err
+		}
+		// the forced version has been applied manually, so accept its file
+		if cd, ok := m.driver.(driver.ChecksumDriver); ok && upFile != nil {
+			checksum, err := upFile.Checksum()
+			if err != nil {
+				return err
+			}
+			return cd.SetChecksum(ctx, version, checksum)
+		}
+		return nil
+	})
+}
+
+// Lease returns the lease of the migration lock, or nil if the lock isn't
+// held. It fails if the driver doesn't implement driver.LeaseDriver.
+func (m *Migrator) Lease(ctx context.Context) (*driver.Lease, error) {
+	ld, ok := m.driver.(driver.LeaseDriver)
+	if !ok {
+		return nil, errors.New("The driver doesn't use a lease lock.")
+	}
+	return ld.Lease(ctx)
+}
+
+// BreakLease removes the lease of the migration lock, whoever holds it.
+// Use it once the holder is known to be gone and its lease would take
+// too long to expire. It fails if the driver doesn't implement
+// driver.LeaseDriver.
+func (m *Migrator) BreakLease(ctx context.Context) error {
+	ld, ok := m.driver.(driver.LeaseDriver)
+	if !ok {
+		return errors.New("The driver doesn't use a lease lock.")
+	}
+	return ld.BreakLease(ctx)
+}
+
+// Up applies all available migrations.
+func (m *Migrator) Up(ctx context.Context, pipe chan interface{}) {
+	pipe = m.observe(pipe)
+	m.locked(ctx, pipe, func(ctx context.Context) bool { return m.up(ctx, pipe) })
+	go pipep.Close(pipe, nil)
+}
+
+// Down rolls back all migrations.
+func (m *Migrator) Down(ctx context.Context, pipe chan interface{}) {
+	pipe = m.observe(pipe)
+	m.locked(ctx, pipe, func(ctx context.Context) bool { return m.down(ctx, pipe) })
+	go pipep.Close(pipe, nil)
+}
+
+// Steps applies relative +n/-n migrations.
+func (m *Migrator) Steps(ctx context.Context, pipe chan interface{}, relativeN int) {
+	pipe = m.observe(pipe)
+	m.locked(ctx, pipe, func(ctx context.Context) bool { return m.steps(ctx, pipe, relativeN) })
+	go pipep.Close(pipe, nil)
+}
+
+// Goto migrates up or down to exactly the given version. It fails
+// if there is no migration file for version, unless it's 0.
+func (m *Migrator) Goto(ctx context.Context, pipe chan interface{}, version uint64) {
+	pipe = m.observe(pipe)
+	m.locked(ctx, pipe, func(ctx context.Context) bool { return m.gotoVersion(ctx, pipe, version) })
+	go pipep.Close(pipe, nil)
+}
+
+// Redo rolls back the most recently applied migration, then runs it again.
+func (m *Migrator) Redo(ctx context.Context, pipe chan interface{}) {
+	pipe = m.observe(pipe)
+	m.locked(ctx, pipe, func(ctx context.Context) bool { return m.redo(ctx, pipe) })
+	go pipep.Close(pipe, nil)
+}
+
+// Reset runs the down and up migration function.
+func (m *Migrator) Reset(ctx context.Context, pipe chan interface{}) {
+	pipe = m.observe(pipe)
+	m.locked(ctx, pipe, func(ctx context.Context) bool { return m.reset(ctx, pipe) })
+	go pipep.Close(pipe, nil)
+}
+
+// withLock runs fn while holding the migration lock, if the driver
+// implements driver.Locker. The context passed to fn is canceled if the
+// driver reports the lock as lost, see driver.LeaseLostNotifier.
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	l, ok := m.driver.(driver.Locker)
+	if !ok {
+		return fn(ctx)
+	}
+	if err := l.Lock(ctx, m.lockTimeout); err != nil {
+		return err
+	}
+	defer func() {
+		if e := l.Unlock(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	if n, ok := m.driver.(driver.LeaseLostNotifier); ok {
+		if lost := n.LeaseLost(); lost != nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				select {
+				case <-lost:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}
+	return fn(ctx)
+}
+
+// locked is like withLock for functions that send their errors to pipe.
+func (m *Migrator) locked(ctx context.Context, pipe chan interface{}, fn func(ctx context.Context) bool) (ok bool) {
+	if err := m.withLock(ctx, func(ctx context.Context) error {
+		ok = fn(ctx)
+		return nil
+	}); err != nil {
+		pipe <- err
+		return false
+	}
+	return ok
+}
+
+func (m *Migrator) up(ctx context.Context, pipe chan interface{}) (ok bool) {
+	return m.run(ctx, pipe, m.upFiles())
+}
+
+func (m *Migrator) down(ctx context.Context, pipe chan interface{}) (ok bool) {
+	return m.run(ctx, pipe, m.downFiles())
+}
+
+func (m *Migrator) steps(ctx context.Context, pipe chan interface{}, relativeN int) (ok bool) {
+	return m.run(ctx, pipe, m.stepsFiles(relativeN))
+}
+
+func (m *Migrator) gotoVersion(ctx context.Context, pipe chan interface{}, toVersion uint64) (ok bool) {
+	return m.run(ctx, pipe, m.gotoFiles(toVersion))
+}
+
+// selection is what a fileSelector selects the files to apply from.
+type selection struct {
+	// the current version
+	version uint64
+
+	// outOfOrder is set if there are unapplied migrations below version
+	// and they may be applied. pending and applied are only set then.
+	outOfOrder bool
+	pending    file.Files // up files that haven't been applied, ascending
+	applied    file.Files // down files of applied versions, descending
+}
+
+// fileSelector returns the files to apply for a selection.
+type fileSelector func(s selection) (file.Files, error)
+
+func (m *Migrator) upFiles() fileSelector {
+	return func(s selection) (file.Files, error) {
+		if s.outOfOrder {
+			return s.pending, nil
+		}
+		return m.files.ToLastFrom(s.version)
+	}
+}
+
+func (m *Migrator) downFiles() fileSelector {
+	return func(s selection) (file.Files, error) {
+		if s.outOfOrder {
+			return s.applied, nil
+		}
+		return m.files.ToFirstFrom(s.version)
+	}
+}
+
+func (m *Migrator) stepsFiles(relativeN int) fileSelector {
+	return func(s selection) (file.Files, error) {
+		if s.outOfOrder {
+			files, n := s.pending, relativeN
+			if relativeN < 0 {
+				files, n = s.applied, -relativeN
+			}
+			if n < len(files) {
+				files = files[:n]
+			}
+			return files, nil
+		}
+		return m.files.From(s.version, relativeN)
+	}
+}
+
+func (m *Migrator) gotoFiles(toVersion uint64) fileSelector {
+	return func(s selection) (file.Files, error) {
+		files, err := m.files.ToVersion(s.version, toVersion)
+		if err != nil || !s.outOfOrder {
+			return files, err
+		}
+		files = make(file.Files, 0)
+		for _, f := range s.applied {
+			if f.Version > toVersion {
+				files = append(files, f)
+			}
+		}
+		for _, f := range s.pending {
+			if f.Version <= toVersion {
+				files = append(files, f)
+			}
+		}
+		return files, nil
+	}
+}
+
+func (m *Migrator) redo(ctx context.Context, pipe chan interface{}) (ok bool) {
+	_, files, err := m.selectFiles(ctx, m.stepsFiles(-1))
+	if err != nil {
+		pipe <- err
+		return false
+	}
+	if len(files) == 0 {
+		return m.steps(ctx, pipe, +1)
+	}
+	if ok := m.migrateFiles(ctx, pipe, files); !ok {
+		return false
+	}
+	// apply the very same version again, which is not necessarily
+	// the next one if out of order migrations are allowed
+	for _, migrationFile := range m.files {
+		if migrationFile.Version == files[0].Version && migrationFile.UpFile != nil {
+			return m.migrateFiles(ctx, pipe, file.Files{*migrationFile.UpFile})
+		}
+	}
+	return true
+}
+
+func (m *Migrator) reset(ctx context.Context, pipe chan interface{}) (ok bool) {
+	if ok := m.down(ctx, pipe); !ok {
+		return false
+	}
+	return m.up(ctx, pipe)
+}
+
+// run applies the files that selectFiles returns.
+func (m *Migrator) run(ctx context.Context, pipe chan interface{}, selectFiles fileSelector) (ok bool) {
+	_, files, err := m.selectFiles(ctx, selectFiles)
+	if err != nil {
+		pipe <- err
+		return false
+	}
+	return m.migrateFiles(ctx, pipe, files)
+}
+
+// selectFiles reads the current version and returns it along with the
+// files that selectFiles returns for it. A dirty database is reported as
+// DirtyError, edited migration files are
+// reported as ChecksumError. If the driver knows all applied versions,
+// unapplied migrations below the current version are reported as
+// OutOfOrderError, unless they are allowed.
+func (m *Migrator) selectFiles(ctx context.Context, selectFiles fileSelector) (uint64, file.Files, error) {
+	version, err := driver.VersionContext(ctx, m.driver)
+	if err != nil {
+		return 0, nil, err
+	}
+	if dd, ok := m.driver.(driver.DirtyDriver); ok {
+		dirty, err := dd.Dirty(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		if dirty != nil {
+			return 0, nil, DirtyError{File: *dirty}
+		}
+	}
+	if err := m.VerifyChecksums(ctx); err != nil {
+		return 0, nil, err
+	}
+	s := selection{version: version}
+
+	applied, ok, err := driver.AppliedVersions(ctx, m.driver)
+	if err != nil {
+		return 0, nil, err
+	}
+	if ok {
+		pending := m.files.Unapplied(applied)
+		outOfOrder := make(file.Files, 0)
+		for _, f := range pending {
+			if f.Version < version {
+				outOfOrder = append(outOfOrder, f)
+			}
+		}
+		if len(outOfOrder) > 0 {
+			if !m.allowOutOfOrder {
+				return 0, nil, OutOfOrderError{Version: version, Files: outOfOrder}
+			}
+			s.outOfOrder = true
+			s.pending = pending
+			s.applied = m.files.Applied(applied)
+		}
+	}
+
+	files, err := selectFiles(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, files, nil
+}
+
+// migrateFiles applies files one after another until one of them
+// fails, an interrupt is received or ctx is done. The database is
+// marked as dirty while a file is applied, and every applied file is
+// added to the history, if the driver supports it.
+func (m *Migrator) migrateFiles(ctx context.Context, pipe chan interface{}, files file.Files) (ok bool) {
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			pipe <- err
+			return false
+		}
+		dd, isDirtyDriver := m.driver.(driver.DirtyDriver)
+		if isDirtyDriver {
+			if err := dd.SetDirty(ctx, f); err != nil {
+				pipe <- err
+				return false
+			}
+		}
+
+		started := time.Now()
+		pipe1 := pipep.New()
+		go driver.MigrateContext(ctx, m.driver, f, pipe1)
+
+		// WaitAndRedirect doesn't tell errors from interrupts, but an
+		// interrupted migration file has still been applied
+		failed := false
+		pipe2 := pipep.New()
+		go func() {
+			for item := range pipe1 {
+				if _, isErr := item.(error); isErr {
+					failed = true
+				}
+				pipe2 <- item
+			}
+			close(pipe2)
+		}()
+		ok := pipep.WaitAndRedirect(pipe2, pipe, m.handleInterrupts())
+
+		if !failed {
+			if isDirtyDriver {
+				if err := dd.ClearDirty(ctx); err != nil {
+					pipe <- err
+					return false
+				}
+			}
+			if err := m.addHistory(ctx, f, started); err != nil {
+				pipe <- err
+				return false
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Migrator) addHistory(ctx context.Context, f file.File, started time.Time) error {
+	hd, ok := m.driver.(driver.HistoryDriver)
+	if !ok {
+		return nil
+	}
+	err := hd.AddHistory(ctx, driver.HistoryEntry{
+		Version:     f.Version,
+		Name:        f.Name,
+		Direction:   f.Direction,
+		AppliedAt:   started,
+		Duration:    time.Since(started),
+		Hostname:    m.hostname,
+		ToolVersion: m.toolVersion,
+		Label:       m.label,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to add %s to the history: %v", f.FileName, err)
+	}
+	return nil
+}
+
+// observe returns a pipe that passes everything on to pipe and
+// translates it into events for the observers. pipe is closed once
+// the returned pipe is closed.
+func (m *Migrator) observe(pipe chan interface{}) chan interface{} {
+	if len(m.observers) == 0 {
+		return pipe
+	}
+	in := pipep.New()
+	go func() {
+		t := &event.Translator{}
+		for item := range in {
+			m.notify(t.Translate(item))
+			pipe <- item
+		}
+		m.notify(t.Flush())
+		close(pipe)
+	}()
+	return in
+}
+
+func (m *Migrator) notify(events []event.Event) {
+	for _, e := range events {
+		for _, o := range m.observers {
+			o.Observe(e)
+		}
+	}
+}
+
+// handleInterrupts returns a signal channel if interrupts checking is
+// enabled. nil otherwise.
+func (m *Migrator) handleInterrupts() chan os.Signal {
+	if m.graceful {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		return c
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package migrate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jfrog/go-dbmigrate/driver"
+	"github.com/jfrog/go-dbmigrate/event"
+	pipep "github.com/jfrog/go-dbmigrate/pipe"
+	"github.com/jfrog/go-dbmigrate/source"
+)
+
+// newSqliteTestDir creates a temporary migrations directory with n empty
+// migrations and returns it along with the url of a sqlite3 database in it.
+func newSqliteTestDir(t *testing.T, n int) (driverUrl, tmpdir string) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	driverUrl = "sqlite3://" + path.Join(tmpdir, "migrate.db")
+	for i := 0; i < n; i++ {
+		if _, err := Create(driverUrl, tmpdir, "migration"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return driverUrl, tmpdir
+}
+
+func runSync(t *testing.T, fn func(pipe chan interface{})) {
+	pipe := pipep.New()
+	go fn(pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+}
+
+func expectVersion(t *testing.T, m *Migrator, expected uint64) {
+	version, err := m.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != expected {
+		t.Fatalf("Expected version %v, got %v", expected, version)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	driverUrl, tmpdir := newSqliteTestDir(t, 3)
+	defer os.RemoveAll(tmpdir)
+
+	started := 0
+	m, err := New(driverUrl, tmpdir, WithObserver(event.ObserverFunc(func(e event.Event) {
+		if _, ok := e.(event.MigrationStarted); ok {
+			started += 1
+		}
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+
+	runSync(t, func(pipe chan interface{}) { m.Up(ctx, pipe) })
+	expectVersion(t, m, 3)
+
+	runSync(t, func(pipe chan interface{}) { m.Steps(ctx, pipe, -2) })
+	expectVersion(t, m, 1)
+
+	runSync(t, func(pipe chan interface{}) { m.Goto(ctx, pipe, 2) })
+	expectVersion(t, m, 2)
+
+	runSync(t, func(pipe chan interface{}) { m.Redo(ctx, pipe) })
+	expectVersion(t, m, 2)
+
+	runSync(t, func(pipe chan interface{}) { m.Reset(ctx, pipe) })
+	expectVersion(t, m, 3)
+
+	runSync(t, func(pipe chan interface{}) { m.Down(ctx, pipe) })
+	expectVersion(t, m, 0)
+
+	// up 3, down 2, up 1, redo 2, reset 5, down 3
+	if started != 16 {
+		t.Errorf("Expected 16 started migrations, got %v", started)
+	}
+}
+
+func TestMigratorFS(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	driverUrl := "sqlite3://" + path.Join(tmpdir, "migrate.db")
+	fsys := fstest.MapFS{
+		"0001_a.up.sql":        {Data: []byte("CREATE TABLE a (id int);")},
+		"0001_a.down.sql":      {Data: []byte("DROP TABLE a;")},
+		"0002_b.up.sql":        {Data: []byte("CREATE TABLE b (id int);")},
+		"0002_b.down.sql":      {Data: []byte("DROP TABLE b;")},
+		"2024/0003_c.up.sql":   {Data: []byte("CREATE TABLE c (id int);")},
+		"2024/0003_c.down.sql": {Data: []byte("DROP TABLE c;")},
+	}
+	ctx := context.Background()
+
+	m, err := NewFS(driverUrl, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	p, err := m.PlanUp(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Steps) != 3 || p.Steps[2].FileName != "2024/0003_c.up.sql" {
+		t.Fatalf("Expected the plan to end with 2024/0003_c.up.sql, got %+v", p.Steps)
+	}
+
+	runSync(t, func(pipe chan interface{}) { m.Up(ctx, pipe) })
+	expectVersion(t, m, 3)
+
+	runSync(t, func(pipe chan interface{}) { m.Down(ctx, pipe) })
+	expectVersion(t, m, 0)
+
+	source.RegisterFS("migrator-test", fsys)
+	m2, err := NewSource(driverUrl, "embed://migrator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m2.Close()
+
+	runSync(t, func(pipe chan interface{}) { m2.Up(ctx, pipe) })
+	expectVersion(t, m2, 3)
+}
+
+func TestOutOfOrder(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	driverUrl := "sqlite3://" + path.Join(tmpdir, "migrate.db")
+	writeFiles := func(names ...string) {
+		for _, name := range names {
+			if err := ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ctx := context.Background()
+
+	writeFiles("0001_a.up.sql", "0001_a.down.sql", "0003_c.up.sql", "0003_c.down.sql")
+	if errs, ok := UpSync(driverUrl, tmpdir); !ok {
+		t.Fatal(errs)
+	}
+
+	// merged from a branch after 0003 has been applied
+	writeFiles("0002_b.up.sql", "0002_b.down.sql")
+
+	errs, ok := UpSync(driverUrl, tmpdir)
+	if ok {
+		t.Fatal("Expected out of order migration to fail")
+	}
+	if e, isOutOfOrder := errs[0].(OutOfOrderError); !isOutOfOrder || len(e.Files) != 1 || e.Files[0].Version != 2 {
+		t.Fatalf("Expected OutOfOrderError for version 2, got %v", errs)
+	}
+
+	m, err := New(driverUrl, tmpdir, AllowOutOfOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	p, err := m.PlanUp(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Steps) != 1 || p.Steps[0].Version != 2 {
+		t.Fatalf("Expected plan to apply version 2, got %+v", p.Steps)
+	}
+
+	runSync(t, func(pipe chan interface{}) { m.Up(ctx, pipe) })
+	expectVersion(t, m, 3)
+	if errs, ok := UpSync(driverUrl, tmpdir); !ok {
+		t.Fatal("Expected no out of order migrations after applying them:", errs)
+	}
+}
+
+func TestLock(t *testing.T) {
+	driverUrl, tmpdir := newSqliteTestDir(t, 1)
+	defer os.RemoveAll(tmpdir)
+	ctx := context.Background()
+
+	holder, err := New(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	if err := holder.driver.(driver.Locker).Lock(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(driverUrl, tmpdir, WithLockTimeout(300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	pipe := pipep.New()
+	go m.Up(ctx, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) != 1 || errs[0] != driver.ErrLocked {
+		t.Fatalf("Expected ErrLocked while another migrator holds the lock, got %v", errs)
+	}
+	expectVersion(t, m, 0)
+
+	if err := holder.driver.(driver.Locker).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	runSync(t, func(pipe chan interface{}) { m.Up(ctx, pipe) })
+	expectVersion(t, m, 1)
+}
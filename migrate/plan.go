@@ -0,0 +1,71 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+// Plan lists the migration files that a migration function would apply,
+// in the order they would be applied.
+type Plan struct {
+	// the migration version at the time the plan was made
+	Version uint64 `json:"version"`
+
+	Steps []PlanStep `json:"steps"`
+}
+
+// PlanStep is one migration file of a Plan.
+type PlanStep struct {
+	Version   uint64              `json:"version"`
+	Name      string              `json:"name"`
+	FileName  string              `json:"file_name"`
+	Direction direction.Direction `json:"direction"`
+
+	// hex encoded SHA-256 hash of the file's content
+	Checksum string `json:"checksum"`
+}
+
+// PlanUp returns what Up would apply.
+func (m *Migrator) PlanUp(ctx context.Context) (*Plan, error) {
+	return m.plan(ctx, m.upFiles())
+}
+
+// PlanDown returns what Down would apply.
+func (m *Migrator) PlanDown(ctx context.Context) (*Plan, error) {
+	return m.plan(ctx, m.downFiles())
+}
+
+// PlanSteps returns what Steps would apply.
+func (m *Migrator) PlanSteps(ctx context.Context, relativeN int) (*Plan, error) {
+	return m.plan(ctx, m.stepsFiles(relativeN))
+}
+
+// PlanGoto returns what Goto would apply.
+func (m *Migrator) PlanGoto(ctx context.Context, version uint64) (*Plan, error) {
+	return m.plan(ctx, m.gotoFiles(version))
+}
+
+// plan reads the current version and describes the files that
+// selectFiles returns for it. Nothing is written to the database.
+func (m *Migrator) plan(ctx context.Context, selectFiles fileSelector) (*Plan, error) {
+	version, files, err := m.selectFiles(ctx, selectFiles)
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{Version: version, Steps: make([]PlanStep, 0, len(files))}
+	for _, f := range files {
+		checksum, err := f.Checksum()
+		if err != nil {
+			return nil, err
+		}
+		p.Steps = append(p.Steps, PlanStep{
+			Version:   f.Version,
+			Name:      f.Name,
+			FileName:  f.FileName,
+			Direction: f.Direction,
+			Checksum:  checksum,
+		})
+	}
+	return p, nil
+}
@@ -0,0 +1,57 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+func TestPlan(t *testing.T) {
+	driverUrl, tmpdir := newSqliteTestDir(t, 3)
+	defer os.RemoveAll(tmpdir)
+
+	m, err := New(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+
+	p, err := m.PlanUp(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != 0 || len(p.Steps) != 3 {
+		t.Fatalf("Expected 3 steps from version 0, got %+v", p)
+	}
+	for i, step := range p.Steps {
+		if step.Version != uint64(i+1) || step.Direction != direction.Up {
+			t.Errorf("Unexpected step %v: %+v", i, step)
+		}
+		// all test migrations are empty files
+		if step.Checksum != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+			t.Errorf("Unexpected checksum %v", step.Checksum)
+		}
+	}
+	expectVersion(t, m, 0)
+
+	runSync(t, func(pipe chan interface{}) { m.Up(ctx, pipe) })
+
+	p, err = m.PlanSteps(ctx, -2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Steps) != 2 || p.Steps[0].Version != 3 || p.Steps[1].Version != 2 || p.Steps[0].Direction != direction.Down {
+		t.Errorf("Expected down steps 3 and 2, got %+v", p.Steps)
+	}
+
+	p, err = m.PlanUp(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Steps) != 0 {
+		t.Errorf("Expected no steps, got %+v", p.Steps)
+	}
+}
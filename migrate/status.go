@@ -0,0 +1,125 @@
+package migrate
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jfrog/go-dbmigrate/driver"
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+// MigrationStatus describes one migration version.
+type MigrationStatus struct {
+	Version uint64 `json:"version"`
+	Name    string `json:"name"`
+
+	Applied bool `json:"applied"`
+
+	HasUpFile   bool `json:"has_up_file"`
+	HasDownFile bool `json:"has_down_file"`
+
+	// Missing is set if the version has been applied, but there are no
+	// migration files for it on disk.
+	Missing bool `json:"missing"`
+
+	// Dirty is set if the version failed halfway, see DirtyError.
+	Dirty bool `json:"dirty"`
+
+	// AppliedAt is when the version has been applied, if the driver
+	// keeps a history.
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Status returns the status of every version that has a migration file
+// or has been applied, in ascending order.
+//
+// With drivers that don't implement driver.AppliedVersionsDriver, every
+// version up to the current version counts as applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	byVersion := make(map[uint64]*MigrationStatus)
+	for _, migrationFile := range m.files {
+		s := &MigrationStatus{
+			Version:     migrationFile.Version,
+			HasUpFile:   migrationFile.UpFile != nil,
+			HasDownFile: migrationFile.DownFile != nil,
+		}
+		if migrationFile.UpFile != nil {
+			s.Name = migrationFile.UpFile.Name
+		} else {
+			s.Name = migrationFile.DownFile.Name
+		}
+		byVersion[s.Version] = s
+	}
+
+	applied, ok, err := driver.AppliedVersions(ctx, m.driver)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		version, err := driver.VersionContext(ctx, m.driver)
+		if err != nil {
+			return nil, err
+		}
+		applied = make([]uint64, 0)
+		for v := range byVersion {
+			if v <= version {
+				applied = append(applied, v)
+			}
+		}
+		if _, exists := byVersion[version]; version > 0 && !exists {
+			applied = append(applied, version)
+		}
+	}
+	for _, v := range applied {
+		s, exists := byVersion[v]
+		if !exists {
+			s = &MigrationStatus{Version: v, Missing: true}
+			byVersion[v] = s
+		}
+		s.Applied = true
+	}
+
+	if dd, ok := m.driver.(driver.DirtyDriver); ok {
+		dirty, err := dd.Dirty(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if dirty != nil {
+			if s, exists := byVersion[dirty.Version]; exists {
+				s.Dirty = true
+			}
+		}
+	}
+
+	if hd, ok := m.driver.(driver.HistoryDriver); ok {
+		history, err := hd.History(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range history {
+			s, exists := byVersion[entry.Version]
+			if !exists {
+				continue
+			}
+			if entry.Direction == direction.Up {
+				appliedAt := entry.AppliedAt
+				s.AppliedAt = &appliedAt
+			} else {
+				s.AppliedAt = nil
+			}
+		}
+	}
+
+	status := make([]MigrationStatus, 0, len(byVersion))
+	for _, s := range byVersion {
+		if !s.Applied {
+			s.AppliedAt = nil
+		}
+		status = append(status, *s)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Version < status[j].Version
+	})
+	return status, nil
+}
@@ -0,0 +1,43 @@
+package migrate
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	driverUrl, tmpdir := newSqliteTestDir(t, 3)
+	defer os.RemoveAll(tmpdir)
+
+	if errs, ok := MigrateSync(driverUrl, tmpdir, +2); !ok {
+		t.Fatal(errs)
+	}
+	if err := os.Remove(path.Join(tmpdir, "0001_migration.up.sql")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(tmpdir, "0001_migration.down.sql")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(tmpdir, "0003_migration.down.sql")); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := Status(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 3 {
+		t.Fatalf("Expected 3 versions, got %+v", status)
+	}
+
+	if s := status[0]; s.Version != 1 || !s.Applied || !s.Missing || s.HasUpFile || s.HasDownFile {
+		t.Errorf("Expected version 1 to be applied, but missing, got %+v", s)
+	}
+	if s := status[1]; s.Version != 2 || !s.Applied || s.Missing || !s.HasUpFile || !s.HasDownFile || s.AppliedAt == nil || s.Name != "migration" {
+		t.Errorf("Expected version 2 to be applied, got %+v", s)
+	}
+	if s := status[2]; s.Version != 3 || s.Applied || !s.HasUpFile || s.HasDownFile || s.AppliedAt != nil {
+		t.Errorf("Expected version 3 to be pending without down file, got %+v", s)
+	}
+}
@@ -0,0 +1,133 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"text/template"
+	"time"
+
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+// DefaultTemplatesDir is the templates directory of Create, relative to
+// the migrations path. Its name starts with a dot, so it's not read for
+// migration files.
+const DefaultTemplatesDir = ".templates"
+
+// TemplateData is passed to the templates of new migration files.
+//
+// The template of the up file of a postgres migration is the first of
+// postgres.up.tmpl and up.sql.tmpl in the templates directory, e.g.
+//
+//	-- {{.VersionString}} {{.Name}} by {{.Author}} on {{.Date.Format "2006-01-02"}}
+//	BEGIN;
+//	SET lock_timeout = '5s';
+//
+//	COMMIT;
+//
+// Files without a template are created empty, or list the Methods.
+type TemplateData struct {
+	Version       uint64
+	VersionString string
+	Name          string
+	Direction     direction.Direction
+
+	// Driver is the name of the driver, e.g. postgres.
+	Driver string
+
+	// FilenameExtension is the extension of the migration files
+	// without the dot, e.g. sql.
+	FilenameExtension string
+
+	// Author is CreateOptions.Author or the current user.
+	Author string
+
+	// Date is the time of creation in UTC.
+	Date time.Time
+
+	// Methods are the migration methods of drivers that migrate with go
+	// methods, if CreateOptions.MethodsFile is set.
+	Methods []string
+}
+
+// templateNames returns the names of the templates for data in the order
+// they're looked up.
+func templateNames(data TemplateData) []string {
+	return []string{
+		fmt.Sprintf("%s.%s.tmpl", data.Driver, data.Direction),
+		fmt.Sprintf("%s.%s.tmpl", data.Direction, data.FilenameExtension),
+	}
+}
+
+// readTemplate returns the template for data in dir, or nil if there's
+// none.
+func readTemplate(dir string, data TemplateData) (*template.Template, error) {
+	for _, name := range templateNames(data) {
+		content, err := ioutil.ReadFile(path.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return template.New(name).Option("missingkey=error").Parse(string(content))
+	}
+	return nil, nil
+}
+
+// executeTemplate returns the content of a new migration file for data.
+func executeTemplate(dir string, data TemplateData) ([]byte, error) {
+	tmpl, err := readTemplate(dir, data)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == nil {
+		var content string
+		for _, method := range data.Methods {
+			content += method + "\n"
+		}
+		return []byte(content), nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// templatesDir returns the templates directory of Create. opts.TemplatesDir
+// is used as is, config.TemplatesDir is relative to migrationsPath. Both
+// must exist, unlike DefaultTemplatesDir.
+func templatesDir(migrationsPath string, opts CreateOptions, config *Config) (string, error) {
+	dir := opts.TemplatesDir
+	if dir == "" && config.TemplatesDir != "" {
+		dir = config.TemplatesDir
+		if !path.IsAbs(dir) {
+			dir = path.Join(migrationsPath, dir)
+		}
+	}
+	if dir == "" {
+		return path.Join(migrationsPath, DefaultTemplatesDir), nil
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return "", err
+	} else if !fi.IsDir() {
+		return "", fmt.Errorf("Templates directory %s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// currentAuthor returns the name of the current user, or an empty string.
+func currentAuthor() string {
+	u, err := user.Current()
+	if err != nil {
+		return os.Getenv("USER")
+	}
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.Username
+}
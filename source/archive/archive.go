@@ -0,0 +1,347 @@
+// Package archive packs migration files into a .tar.gz or .zip archive
+// with a manifest, and is a source of the migration files of such
+// archives, e.g. archive://./migrations.tar.gz.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jfrog/go-dbmigrate/file"
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+	"github.com/jfrog/go-dbmigrate/source"
+)
+
+func init() {
+	source.Register("archive", open)
+}
+
+// ManifestName is the name of the manifest in an archive.
+const ManifestName = "manifest.json"
+
+// The formats of archives.
+const (
+	TarGz = "tar.gz"
+	Zip   = "zip"
+)
+
+// Manifest lists the migration files of an archive.
+type Manifest struct {
+	ToolVersion string         `json:"tool_version"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Files       []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a migration file in a Manifest.
+type ManifestFile struct {
+	FileName  string              `json:"file_name"`
+	Version   uint64              `json:"version"`
+	Name      string              `json:"name"`
+	Direction direction.Direction `json:"direction"`
+	Checksum  string              `json:"sha256"`
+}
+
+// anyFilenameRegex matches migration files of all drivers.
+var anyFilenameRegex = file.FilenameRegex(`[^.]+`)
+
+// Format returns the format of the archive filename by its extension.
+func Format(filename string) (string, error) {
+	switch {
+	case strings.HasSuffix(filename, ".tar.gz"), strings.HasSuffix(filename, ".tgz"):
+		return TarGz, nil
+	case strings.HasSuffix(filename, ".zip"):
+		return Zip, nil
+	}
+	return "", fmt.Errorf("Unknown archive format of %s, use .tar.gz or .zip", filename)
+}
+
+// Pack writes an archive in format to w, with the migration files of all
+// drivers in the root directory of fsys and its subdirectories, like
+// file.ReadMigrationFilesFS, and a manifest of them.
+func Pack(w io.Writer, format string, fsys fs.FS, toolVersion string) (*Manifest, error) {
+	manifest := &Manifest{ToolVersion: toolVersion, CreatedAt: time.Now().UTC(), Files: make([]ManifestFile, 0)}
+	contents := make(map[string][]byte)
+	err := fs.WalkDir(fsys, ".", func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filename != "." && strings.HasPrefix(entry.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		version, name, d, err := file.ParseFilenameSchema(entry.Name(), anyFilenameRegex)
+		if err != nil {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, filename)
+		if err != nil {
+			return err
+		}
+		contents[filename] = content
+		manifest.Files = append(manifest.Files, ManifestFile{
+			FileName:  filename,
+			Version:   version,
+			Name:      name,
+			Direction: d,
+			Checksum:  checksum(content),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		if manifest.Files[i].Version != manifest.Files[j].Version {
+			return manifest.Files[i].Version < manifest.Files[j].Version
+		}
+		return manifest.Files[i].FileName < manifest.Files[j].FileName
+	})
+
+	manifestContent, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case TarGz:
+		err = writeTarGz(w, manifest, manifestContent, contents)
+	case Zip:
+		err = writeZip(w, manifest, manifestContent, contents)
+	default:
+		err = fmt.Errorf("Unknown archive format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func writeTarGz(w io.Writer, manifest *Manifest, manifestContent []byte, contents map[string][]byte) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	write := func(name string, content []byte) error {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+	if err := write(ManifestName, manifestContent); err != nil {
+		return err
+	}
+	for _, f := range manifest.Files {
+		if err := write(f.FileName, contents[f.FileName]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func writeZip(w io.Writer, manifest *Manifest, manifestContent []byte, contents map[string][]byte) error {
+	zw := zip.NewWriter(w)
+	write := func(name string, content []byte) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: manifest.CreatedAt})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(content)
+		return err
+	}
+	if err := write(ManifestName, manifestContent); err != nil {
+		return err
+	}
+	for _, f := range manifest.Files {
+		if err := write(f.FileName, contents[f.FileName]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Archive is a source of the migration files of an archive for one
+// filename extension. The archive is read into memory and verified
+// against its manifest when it's opened.
+type Archive struct {
+	*source.Migrations
+	Manifest Manifest
+
+	contents map[string][]byte
+}
+
+// open opens urls like archive://./migrations.tar.gz.
+func open(url, filenameExtension string) (source.Source, error) {
+	path := strings.TrimPrefix(url, "archive://")
+	format, err := Format(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, format, filenameExtension)
+}
+
+// Read reads an archive in format from r and verifies the checksums of
+// its migration files against its manifest. It returns an Archive of the
+// migration files with filenameExtension.
+func Read(r io.Reader, format string, filenameExtension string) (*Archive, error) {
+	var contents map[string][]byte
+	var err error
+	switch format {
+	case TarGz:
+		contents, err = readTarGz(r)
+	case Zip:
+		contents, err = readZip(r)
+	default:
+		err = fmt.Errorf("Unknown archive format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{Migrations: source.NewMigrations(), contents: contents}
+	manifestContent, ok := contents[ManifestName]
+	if !ok {
+		return nil, fmt.Errorf("The archive has no %s", ManifestName)
+	}
+	if err := json.Unmarshal(manifestContent, &a.Manifest); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %v", ManifestName, err)
+	}
+	if err := a.verify(); err != nil {
+		return nil, err
+	}
+
+	filenameRegex := file.FilenameRegex(filenameExtension)
+	for _, f := range a.Manifest.Files {
+		if !filenameRegex.MatchString(path.Base(f.FileName)) {
+			continue
+		}
+		if err := a.Add(f.Version, f.Direction, f.FileName); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// verify checks that the archive holds exactly the files of the manifest,
+// with their checksums.
+func (a *Archive) verify() error {
+	listed := make(map[string]bool)
+	for _, f := range a.Manifest.Files {
+		content, ok := a.contents[f.FileName]
+		if !ok {
+			return fmt.Errorf("%s is in the manifest, but not in the archive", f.FileName)
+		}
+		if sum := checksum(content); sum != f.Checksum {
+			return fmt.Errorf("Checksum mismatch of %s: the manifest says sha256:%s, the archive has sha256:%s", f.FileName, f.Checksum, sum)
+		}
+		listed[f.FileName] = true
+	}
+	for name := range a.contents {
+		if name != ManifestName && !listed[name] {
+			return fmt.Errorf("%s is in the archive, but not in the manifest", name)
+		}
+	}
+	return nil
+}
+
+func (a *Archive) ReadUp(version uint64) (io.ReadCloser, string, error) {
+	filename, ok := a.Up(version)
+	return a.read(filename, ok)
+}
+
+func (a *Archive) ReadDown(version uint64) (io.ReadCloser, string, error) {
+	filename, ok := a.Down(version)
+	return a.read(filename, ok)
+}
+
+func (a *Archive) Close() error {
+	return nil
+}
+
+func (a *Archive) read(filename string, ok bool) (io.ReadCloser, string, error) {
+	if !ok {
+		return nil, "", os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(a.contents[filename])), filename, nil
+}
+
+func readTarGz(r io.Reader) (map[string][]byte, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	contents := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return contents, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		contents[strings.TrimPrefix(header.Name, "./")] = content
+	}
+}
+
+func readZip(r io.Reader) (map[string][]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	contents := make(map[string][]byte)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		contents[f.Name] = content
+	}
+	return contents, nil
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
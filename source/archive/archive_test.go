@@ -0,0 +1,112 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+	"github.com/jfrog/go-dbmigrate/source"
+)
+
+var testFS = fstest.MapFS{
+	"001_a.up.sql":      {Data: []byte("CREATE TABLE a (id int);")},
+	"001_a.down.sql":    {Data: []byte("DROP TABLE a;")},
+	"002_b.up.sql":      {Data: []byte("CREATE TABLE b (id int);")},
+	"002_b.up.sh":       {Data: []byte("exit 0")},
+	"2024/003_c.up.sql": {Data: []byte("CREATE TABLE c (id int);")},
+	"README.md":         {Data: []byte("not a migration")},
+}
+
+func TestPackAndRead(t *testing.T) {
+	for _, format := range []string{TarGz, Zip} {
+		var buf bytes.Buffer
+		manifest, err := Pack(&buf, format, testFS, "1.0.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Files) != 5 || manifest.ToolVersion != "1.0.0" {
+			t.Fatalf("Unexpected manifest %+v", manifest)
+		}
+
+		a, err := Read(bytes.NewReader(buf.Bytes()), format, "sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions, err := source.Versions(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(versions, []uint64{1, 2, 3}) {
+			t.Errorf("Expected versions 1, 2 and 3 in %v, got %v", format, versions)
+		}
+		if _, filename, err := a.ReadUp(3); err != nil || filename != "2024/003_c.up.sql" {
+			t.Errorf("Expected 2024/003_c.up.sql in %v, got %v, %v", format, filename, err)
+		}
+
+		r, filename, err := a.ReadUp(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(r)
+		if filename != "002_b.up.sql" || string(content) != "CREATE TABLE b (id int);" {
+			t.Errorf("Unexpected up migration %v in %v: %q", filename, format, content)
+		}
+	}
+}
+
+// tarGz returns a .tar.gz archive of files.
+func tarGz(t *testing.T, files map[string][]byte) *bytes.Buffer {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestReadVerifiesManifest(t *testing.T) {
+	up := []byte("CREATE TABLE a (id int);")
+	manifest, err := json.Marshal(Manifest{Files: []ManifestFile{
+		{FileName: "001_a.up.sql", Version: 1, Name: "a", Direction: direction.Up, Checksum: checksum(up)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Read(tarGz(t, map[string][]byte{ManifestName: manifest, "001_a.up.sql": up}), TarGz, "sql"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		files map[string][]byte
+	}{
+		{"edited file", map[string][]byte{ManifestName: manifest, "001_a.up.sql": []byte("DROP TABLE a;")}},
+		{"missing file", map[string][]byte{ManifestName: manifest}},
+		{"unlisted file", map[string][]byte{ManifestName: manifest, "001_a.up.sql": up, "002_b.up.sql": nil}},
+		{"missing manifest", map[string][]byte{"001_a.up.sql": up}},
+	}
+	for _, test := range tests {
+		if _, err := Read(tarGz(t, test.files), TarGz, "sql"); err == nil {
+			t.Errorf("Expected error for an archive with a %v", test.name)
+		}
+	}
+}
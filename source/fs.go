@@ -0,0 +1,143 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jfrog/go-dbmigrate/file"
+)
+
+func init() {
+	Register("file", openDir)
+	Register("embed", openEmbed)
+}
+
+// fsSource is a Source of the migration files in the root directory of a
+// file system.
+type fsSource struct {
+	fsys  fs.FS
+	files file.MigrationFiles
+}
+
+// NewFS returns a Source of the migration files with filenameExtension in
+// the root directory of fsys, e.g. an embed.FS. Use fs.Sub for migration
+// files in a subdirectory.
+func NewFS(fsys fs.FS, filenameExtension string) (Source, error) {
+	files, err := file.ReadMigrationFilesFS(fsys, file.FilenameRegex(filenameExtension))
+	if err != nil {
+		return nil, err
+	}
+	return &fsSource{fsys: fsys, files: files}, nil
+}
+
+// NewDir returns a Source of the migration files with filenameExtension in
+// the directory path.
+func NewDir(path, filenameExtension string) (Source, error) {
+	files, err := file.ReadMigrationFiles(path, file.FilenameRegex(filenameExtension))
+	if err != nil {
+		return nil, err
+	}
+	return &fsSource{fsys: os.DirFS(path), files: files}, nil
+}
+
+// openDir opens urls like file://./migrations or file:///srv/migrations.
+func openDir(url, filenameExtension string) (Source, error) {
+	path := strings.TrimPrefix(url, "file://")
+	if path == "" {
+		return nil, fmt.Errorf("Missing path in source url %s", url)
+	}
+	return NewDir(path, filenameExtension)
+}
+
+var embedsMu sync.Mutex
+var embeds = make(map[string]fs.FS)
+
+// RegisterFS registers fsys so that its migration files can be opened by
+// the url embed://name, e.g. from the -source flag of a program that
+// compiles its migration files in with //go:embed.
+func RegisterFS(name string, fsys fs.FS) {
+	embedsMu.Lock()
+	defer embedsMu.Unlock()
+	if _, dup := embeds[name]; dup {
+		panic("source: RegisterFS called twice for " + name)
+	}
+	embeds[name] = fsys
+}
+
+// openEmbed opens urls like embed://name of a file system registered with
+// RegisterFS.
+func openEmbed(url, filenameExtension string) (Source, error) {
+	name := strings.TrimPrefix(url, "embed://")
+	embedsMu.Lock()
+	fsys, ok := embeds[name]
+	embedsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("No file system registered as %s", url)
+	}
+	return NewFS(fsys, filenameExtension)
+}
+
+func (s *fsSource) First() (uint64, error) {
+	if len(s.files) == 0 {
+		return 0, os.ErrNotExist
+	}
+	return s.files[0].Version, nil
+}
+
+func (s *fsSource) Prev(version uint64) (uint64, error) {
+	i := s.index(version)
+	if i <= 0 {
+		return 0, os.ErrNotExist
+	}
+	return s.files[i-1].Version, nil
+}
+
+func (s *fsSource) Next(version uint64) (uint64, error) {
+	i := s.index(version)
+	if i < 0 || i+1 >= len(s.files) {
+		return 0, os.ErrNotExist
+	}
+	return s.files[i+1].Version, nil
+}
+
+func (s *fsSource) ReadUp(version uint64) (io.ReadCloser, string, error) {
+	i := s.index(version)
+	if i < 0 || s.files[i].UpFile == nil {
+		return nil, "", os.ErrNotExist
+	}
+	return s.open(s.files[i].UpFile)
+}
+
+func (s *fsSource) ReadDown(version uint64) (io.ReadCloser, string, error) {
+	i := s.index(version)
+	if i < 0 || s.files[i].DownFile == nil {
+		return nil, "", os.ErrNotExist
+	}
+	return s.open(s.files[i].DownFile)
+}
+
+func (s *fsSource) Close() error {
+	return nil
+}
+
+// index returns the index of version in the files, or -1.
+func (s *fsSource) index(version uint64) int {
+	for i, migrationFile := range s.files {
+		if migrationFile.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *fsSource) open(f *file.File) (io.ReadCloser, string, error) {
+	r, err := s.fsys.Open(f.FileName)
+	if err != nil {
+		return nil, "", err
+	}
+	return r, f.FileName, nil
+}
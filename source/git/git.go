@@ -0,0 +1,156 @@
+// Package git is a source of the migration files in a directory of a git
+// revision, e.g. git:///srv/repo?ref=release-3.2&path=db/migrations.
+// The files are read from the objects of a local repository with the git
+// command, without checking the revision out.
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	pathpkg "path"
+	"strings"
+
+	"github.com/jfrog/go-dbmigrate/file"
+	"github.com/jfrog/go-dbmigrate/source"
+)
+
+func init() {
+	source.Register("git", open)
+}
+
+// Git is a source of the migration files in a directory of a git revision
+// and its subdirectories.
+type Git struct {
+	*source.Migrations
+
+	// Commit is the commit the revision resolved to when the source was
+	// opened. All migration files are read from it, even if the ref moves.
+	Commit string
+
+	repo  string
+	blobs map[string]string
+}
+
+// open opens urls like git:///srv/repo?ref=main&path=db/migrations. The
+// ref defaults to HEAD and the path to the root of the repository.
+func open(url, filenameExtension string) (source.Source, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	repo := u.Host + u.Path
+	if repo == "" {
+		repo = "."
+	}
+	ref := u.Query().Get("ref")
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return New(repo, ref, u.Query().Get("path"), filenameExtension)
+}
+
+// New returns a Git source of the migration files with filenameExtension
+// in the directory path of the revision ref of the repository repo.
+func New(repo, ref, path, filenameExtension string) (*Git, error) {
+	if strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("Invalid ref %q", ref)
+	}
+	commit, err := run(repo, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("Unknown revision %s in %s: %v", ref, repo, err)
+	}
+	g := &Git{
+		Migrations: source.NewMigrations(),
+		Commit:     strings.TrimSpace(string(commit)),
+		repo:       repo,
+		blobs:      make(map[string]string),
+	}
+
+	path = strings.Trim(path, "/")
+	tree, err := run(repo, "ls-tree", "-r", "-z", g.Commit+":"+path)
+	if err != nil {
+		return nil, fmt.Errorf("No directory %q in %s: %v", path, ref, err)
+	}
+	filenameRegex := file.FilenameRegex(filenameExtension)
+	for _, entry := range strings.Split(string(tree), "\x00") {
+		// <mode> SP <type> SP <object> TAB <file>
+		tab := strings.Index(entry, "\t")
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(entry[:tab])
+		filename := entry[tab+1:]
+		if len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		if hidden(filename) {
+			continue
+		}
+		version, _, d, err := file.ParseFilenameSchema(pathpkg.Base(filename), filenameRegex)
+		if err != nil {
+			continue
+		}
+		if err := g.Add(version, d, filename); err != nil {
+			return nil, err
+		}
+		g.blobs[filename] = fields[2]
+	}
+	return g, nil
+}
+
+func (g *Git) ReadUp(version uint64) (io.ReadCloser, string, error) {
+	filename, ok := g.Up(version)
+	return g.read(filename, ok)
+}
+
+func (g *Git) ReadDown(version uint64) (io.ReadCloser, string, error) {
+	filename, ok := g.Down(version)
+	return g.read(filename, ok)
+}
+
+func (g *Git) Close() error {
+	return nil
+}
+
+func (g *Git) read(filename string, ok bool) (io.ReadCloser, string, error) {
+	if !ok {
+		return nil, "", os.ErrNotExist
+	}
+	content, err := run(g.repo, "cat-file", "blob", g.blobs[filename])
+	if err != nil {
+		return nil, "", err
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), filename, nil
+}
+
+// hidden reports whether filename is in a directory whose name starts with
+// a dot, which file.ReadMigrationFilesFS skips.
+func hidden(filename string) bool {
+	dirs := strings.Split(filename, "/")
+	for _, dir := range dirs[:len(dirs)-1] {
+		if strings.HasPrefix(dir, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// run runs git with args in repo and returns its output.
+func run(repo string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,96 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/jfrog/go-dbmigrate/source"
+)
+
+func TestGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo, err := ioutil.TempDir("/tmp", "git-source-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	writeFile := func(name, content string) {
+		if err := os.MkdirAll(path.Join(repo, path.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q")
+	writeFile("db/migrations/001_a.up.sql", "CREATE TABLE a (id int);")
+	writeFile("db/migrations/001_a.down.sql", "DROP TABLE a;")
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	git("tag", "release-1")
+
+	writeFile("db/migrations/001_a.up.sql", "CREATE TABLE a (id bigint);")
+	writeFile("db/migrations/002_b.up.sql", "CREATE TABLE b (id int);")
+	writeFile("db/other/2024/003_c.up.sql", "CREATE TABLE c (id int);")
+	writeFile("db/other/.old/001_a.up.sql", "CREATE TABLE a (id int);")
+	git("add", "-A")
+	git("commit", "-q", "-m", "second")
+
+	tests := []struct {
+		url      string
+		versions []uint64
+		up       string
+		err      bool
+	}{
+		{url: "git://" + repo + "?ref=release-1&path=db/migrations", versions: []uint64{1}, up: "CREATE TABLE a (id int);"},
+		{url: "git://" + repo + "?path=/db/migrations/", versions: []uint64{1, 2}, up: "CREATE TABLE a (id bigint);"},
+		{url: "git://" + repo, versions: []uint64{1, 2, 3}},
+		{url: "git://" + repo + "?path=db/other", versions: []uint64{3}},
+		{url: "git://" + repo + "?ref=release-2&path=db/migrations", err: true},
+		{url: "git://" + repo + "?ref=release-1&path=db/missing", err: true},
+	}
+	for _, test := range tests {
+		src, err := source.Open(test.url, "sql")
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error for %v", test.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", test.url, err)
+			continue
+		}
+		versions, err := source.Versions(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(versions, test.versions) {
+			t.Errorf("Expected versions %v for %v, got %v", test.versions, test.url, versions)
+		}
+		if test.up != "" {
+			r, _, err := src.ReadUp(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := ioutil.ReadAll(r)
+			if string(content) != test.up {
+				t.Errorf("Expected up migration %q for %v, got %q", test.up, test.url, content)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+	"github.com/jfrog/go-dbmigrate/source"
+	"github.com/jfrog/go-dbmigrate/source/archive"
+)
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// newServer serves files under /migrations/ with an index of them, if
+// requests are authorized by authorized.
+func newServer(t *testing.T, files map[string]string, authorized func(r *nethttp.Request) bool) *httptest.Server {
+	index := archive.Manifest{ToolVersion: "1.0.0"}
+	for name, content := range files {
+		version := uint64(1)
+		if strings.HasPrefix(name, "002") {
+			version = 2
+		}
+		d := direction.Up
+		if strings.Contains(name, ".down.") {
+			d = direction.Down
+		}
+		index.Files = append(index.Files, archive.ManifestFile{FileName: name, Version: version, Direction: d, Checksum: checksum(content)})
+	}
+	indexContent, err := json.Marshal(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if !authorized(r) {
+			w.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/migrations/")
+		if name == "index.json" {
+			w.Write(indexContent)
+			return
+		}
+		content, ok := files[name]
+		if !ok {
+			nethttp.NotFound(w, r)
+			return
+		}
+		if name == "002_b.up.sql" {
+			// served with a different content than in the index
+			content += "DROP TABLE a;"
+		}
+		w.Write([]byte(content))
+	}))
+}
+
+func TestHTTP(t *testing.T) {
+	files := map[string]string{
+		"001_a.up.sql":   "CREATE TABLE a (id int);",
+		"001_a.down.sql": "DROP TABLE a;",
+		"002_b.up.sql":   "CREATE TABLE b (id int);",
+		"002_b.up.sh":    "exit 0",
+	}
+	server := newServer(t, files, func(r *nethttp.Request) bool {
+		user, password, _ := r.BasicAuth()
+		return r.Header.Get("Authorization") == "Bearer secret" || (user == "ci" && password == "pass")
+	})
+	defer server.Close()
+
+	h, err := New(server.URL+"/migrations/index.json", "sql", Config{BearerToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := source.Versions(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions, []uint64{1, 2}) {
+		t.Fatalf("Expected versions 1 and 2, got %v", versions)
+	}
+
+	r, filename, err := h.ReadDown(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(r)
+	if filename != "001_a.down.sql" || string(content) != files["001_a.down.sql"] {
+		t.Errorf("Unexpected down migration %v: %q", filename, content)
+	}
+
+	if _, _, err := h.ReadUp(2); err == nil || !strings.Contains(err.Error(), "Checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch for 002_b.up.sql, got %v", err)
+	}
+
+	// basic authentication with the user info of the url
+	u := strings.Replace(server.URL, "http://", "http://ci:pass@", 1)
+	if _, err := source.Open(u+"/migrations/index.json", "sql"); err != nil {
+		t.Errorf("Unexpected error with basic authentication: %v", err)
+	}
+	if _, err := source.Open(server.URL+"/migrations/index.json", "sql"); err == nil {
+		t.Error("Expected error without authentication")
+	}
+	if _, err := New(server.URL+"/migrations/missing.json", "sql", Config{BearerToken: "secret"}); err == nil {
+		t.Error("Expected error for a missing index")
+	}
+}
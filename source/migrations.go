@@ -0,0 +1,89 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+// Migrations indexes the filenames of migration files by version, for
+// Sources that don't have an index of their own.
+type Migrations struct {
+	versions []uint64
+	up       map[uint64]string
+	down     map[uint64]string
+}
+
+// NewMigrations returns an empty index.
+func NewMigrations() *Migrations {
+	return &Migrations{
+		up:   make(map[uint64]string),
+		down: make(map[uint64]string),
+	}
+}
+
+// Add adds the migration file filename of version in direction d. It
+// fails if there's a migration file for version and d already.
+func (m *Migrations) Add(version uint64, d direction.Direction, filename string) error {
+	files := m.up
+	if d == direction.Down {
+		files = m.down
+	}
+	if existing, ok := files[version]; ok {
+		return fmt.Errorf("duplicate migration file version %d : %q and %q", version, existing, filename)
+	}
+	if i := m.index(version); i < 0 {
+		i = sort.Search(len(m.versions), func(i int) bool { return m.versions[i] > version })
+		m.versions = append(m.versions, 0)
+		copy(m.versions[i+1:], m.versions[i:])
+		m.versions[i] = version
+	}
+	files[version] = filename
+	return nil
+}
+
+func (m *Migrations) First() (uint64, error) {
+	if len(m.versions) == 0 {
+		return 0, os.ErrNotExist
+	}
+	return m.versions[0], nil
+}
+
+func (m *Migrations) Prev(version uint64) (uint64, error) {
+	i := m.index(version)
+	if i <= 0 {
+		return 0, os.ErrNotExist
+	}
+	return m.versions[i-1], nil
+}
+
+func (m *Migrations) Next(version uint64) (uint64, error) {
+	i := m.index(version)
+	if i < 0 || i+1 >= len(m.versions) {
+		return 0, os.ErrNotExist
+	}
+	return m.versions[i+1], nil
+}
+
+// Up returns the filename of the up migration file of version.
+func (m *Migrations) Up(version uint64) (filename string, ok bool) {
+	filename, ok = m.up[version]
+	return filename, ok
+}
+
+// Down returns the filename of the down migration file of version.
+func (m *Migrations) Down(version uint64) (filename string, ok bool) {
+	filename, ok = m.down[version]
+	return filename, ok
+}
+
+// index returns the index of version in versions, or -1.
+func (m *Migrations) index(version uint64) int {
+	i := sort.Search(len(m.versions), func(i int) bool { return m.versions[i] >= version })
+	if i == len(m.versions) || m.versions[i] != version {
+		return -1
+	}
+	return i
+}
@@ -0,0 +1,62 @@
+package source
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OpenFunc opens the migration files with filenameExtension at url,
+// e.g. file://./migrations.
+type OpenFunc func(url, filenameExtension string) (Source, error)
+
+var sourcesMu sync.Mutex
+var sources = make(map[string]OpenFunc)
+
+// Register registers a source so it can be opened by urls with name as
+// their scheme. Sources should call this from an init() function so that
+// they register themselves on import.
+func Register(name string, open OpenFunc) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	if open == nil {
+		panic("source: Register source is nil")
+	}
+	if _, dup := sources[name]; dup {
+		panic("source: Register called twice for source " + name)
+	}
+	sources[name] = open
+}
+
+// Sources returns a sorted list of the names of the registered sources.
+func Sources() []string {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	var list []string
+	for name := range sources {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Open opens the migration files with filenameExtension at url with the
+// source registered for the scheme of url. A url without a scheme is a
+// path to a directory.
+func Open(url, filenameExtension string) (Source, error) {
+	scheme := "file"
+	if i := strings.Index(url, "://"); i >= 0 {
+		scheme = url[:i]
+	} else {
+		url = "file://" + url
+	}
+
+	sourcesMu.Lock()
+	open, ok := sources[scheme]
+	sourcesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Source '%s' not found.", scheme)
+	}
+	return open(url, filenameExtension)
+}
@@ -0,0 +1,215 @@
+// Package source contains the Source interface that migration files are
+// read through, and the registry of sources.
+package source
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jfrog/go-dbmigrate/migrate/direction"
+)
+
+// Source is where the migration files of a driver come from, e.g. a
+// directory. Drivers don't know about sources, they get the content of
+// migration files from migrate.
+type Source interface {
+	// First returns the lowest version. It returns os.ErrNotExist if there
+	// are no migration files.
+	First() (version uint64, err error)
+
+	// Prev returns the version before version. It returns os.ErrNotExist
+	// if version is the first one or doesn't exist.
+	Prev(version uint64) (prevVersion uint64, err error)
+
+	// Next returns the version after version. It returns os.ErrNotExist
+	// if version is the last one or doesn't exist.
+	Next(version uint64) (nextVersion uint64, err error)
+
+	// ReadUp opens the up migration file of version and returns its
+	// content along with its filename. It returns os.ErrNotExist if
+	// there's no up migration file for version.
+	ReadUp(version uint64) (r io.ReadCloser, filename string, err error)
+
+	// ReadDown is like ReadUp for the down migration file of version.
+	ReadDown(version uint64) (r io.ReadCloser, filename string, err error)
+
+	// Close closes the source.
+	Close() error
+}
+
+// Versions returns the versions of src in ascending order.
+func Versions(src Source) ([]uint64, error) {
+	versions := make([]uint64, 0)
+	version, err := src.First()
+	for err == nil {
+		versions = append(versions, version)
+		version, err = src.Next(version)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// FS returns the migration files of src as the root directory of a file
+// system, so they can be read with file.ReadMigrationFilesFS. The file
+// system implements fs.ReadDirFS and fs.StatFS.
+func FS(src Source) (fs.FS, error) {
+	if s, ok := src.(*fsSource); ok {
+		return s.fsys, nil
+	}
+
+	versions, err := Versions(src)
+	if err != nil {
+		return nil, err
+	}
+	sfs := &sourceFS{src: src, files: make(map[string]sourceFile)}
+	for _, version := range versions {
+		for _, d := range []direction.Direction{direction.Up, direction.Down} {
+			filename, err := filenameOf(src, version, d)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			sfs.files[filename] = sourceFile{version: version, d: d}
+			sfs.names = append(sfs.names, filename)
+		}
+	}
+	return sfs, nil
+}
+
+// indexed is implemented by Sources that embed Migrations.
+type indexed interface {
+	Up(version uint64) (filename string, ok bool)
+	Down(version uint64) (filename string, ok bool)
+}
+
+// filenameOf returns the filename of the migration file of version in
+// direction d. It's looked up in the index of src, if it has one, rather
+// than read.
+func filenameOf(src Source, version uint64, d direction.Direction) (string, error) {
+	if index, ok := src.(indexed); ok {
+		lookup := index.Up
+		if d == direction.Down {
+			lookup = index.Down
+		}
+		if filename, ok := lookup(version); ok {
+			return filename, nil
+		}
+		return "", os.ErrNotExist
+	}
+	r, filename, err := read(src, version, d)
+	if err != nil {
+		return "", err
+	}
+	r.Close()
+	return filename, nil
+}
+
+func read(src Source, version uint64, d direction.Direction) (io.ReadCloser, string, error) {
+	if d == direction.Down {
+		return src.ReadDown(version)
+	}
+	return src.ReadUp(version)
+}
+
+// sourceFS is a read-only file system of the migration files of a Source.
+// Their filenames may include directories, see file.File.
+type sourceFS struct {
+	src   Source
+	files map[string]sourceFile
+	names []string
+}
+
+type sourceFile struct {
+	version uint64
+	d       direction.Direction
+}
+
+func (s *sourceFS) Open(name string) (fs.File, error) {
+	f, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	r, _, err := read(s.src, f.version, f.d)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &openFile{ReadCloser: r, name: name}, nil
+}
+
+func (s *sourceFS) Stat(name string) (fs.FileInfo, error) {
+	if _, ok := s.files[name]; ok {
+		return fileInfo{name: path.Base(name)}, nil
+	}
+	if name == "." {
+		return fileInfo{name: ".", dir: true}, nil
+	}
+	for _, filename := range s.names {
+		if strings.HasPrefix(filename, name+"/") {
+			return fileInfo{name: path.Base(name), dir: true}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (s *sourceFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries := make([]fs.DirEntry, 0)
+	dirs := make(map[string]bool)
+	for _, filename := range s.names {
+		rel := filename
+		if name != "." {
+			if !strings.HasPrefix(filename, name+"/") {
+				continue
+			}
+			rel = filename[len(name)+1:]
+		}
+		if i := strings.Index(rel, "/"); i >= 0 {
+			if dir := rel[:i]; !dirs[dir] {
+				dirs[dir] = true
+				entries = append(entries, fs.FileInfoToDirEntry(fileInfo{name: dir, dir: true}))
+			}
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(fileInfo{name: rel}))
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+type openFile struct {
+	io.ReadCloser
+	name string
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) {
+	return fileInfo{name: path.Base(f.name)}, nil
+}
+
+// fileInfo describes a migration file of unknown size, or a directory.
+type fileInfo struct {
+	name string
+	dir  bool
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return 0 }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return fi.dir }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
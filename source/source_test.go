@@ -0,0 +1,153 @@
+package source
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jfrog/go-dbmigrate/file"
+)
+
+var testFS = fstest.MapFS{
+	"001_a.up.sql":      {Data: []byte("CREATE TABLE a (id int);")},
+	"001_a.down.sql":    {Data: []byte("DROP TABLE a;")},
+	"003_c.up.sql":      {Data: []byte("CREATE TABLE c (id int);")},
+	"007_g.up.sql":      {Data: []byte("CREATE TABLE g (id int);")},
+	"007_g.down.sql":    {Data: []byte("DROP TABLE g;")},
+	"007_g.up.sh":       {Data: []byte("exit 1")},
+	"2024/008_h.up.sql": {Data: []byte("CREATE TABLE h (id int);")},
+	".old/009_i.up.sql": {Data: []byte("CREATE TABLE i (id int);")},
+}
+
+func readAll(t *testing.T, read func(uint64) (r io.ReadCloser, filename string, err error), version uint64) (string, string) {
+	r, filename, err := read(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename, string(content)
+}
+
+func TestFSSource(t *testing.T) {
+	src, err := NewFS(testFS, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	versions, err := Versions(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions, []uint64{1, 3, 7, 8}) {
+		t.Fatalf("Expected versions 1, 3, 7 and 8, got %v", versions)
+	}
+
+	if prev, err := src.Prev(7); err != nil || prev != 3 {
+		t.Errorf("Expected 3 before 7, got %v, %v", prev, err)
+	}
+	if _, err := src.Prev(1); !os.IsNotExist(err) {
+		t.Errorf("Expected no version before 1, got %v", err)
+	}
+	if _, err := src.Next(8); !os.IsNotExist(err) {
+		t.Errorf("Expected no version after 8, got %v", err)
+	}
+	if _, err := src.Next(2); !os.IsNotExist(err) {
+		t.Errorf("Expected an error for the unknown version 2, got %v", err)
+	}
+
+	filename, content := readAll(t, src.ReadDown, 7)
+	if filename != "007_g.down.sql" || content != "DROP TABLE g;" {
+		t.Errorf("Unexpected down migration %v: %q", filename, content)
+	}
+	if _, _, err := src.ReadDown(3); !os.IsNotExist(err) {
+		t.Errorf("Expected no down migration for version 3, got %v", err)
+	}
+}
+
+// wrapped hides the type of a Source from FS.
+type wrapped struct {
+	Source
+}
+
+func TestFS(t *testing.T) {
+	src, err := NewFS(testFS, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := FS(wrapped{src})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := file.ReadMigrationFilesFS(fsys, file.FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 || files[1].Version != 3 || files[1].DownFile != nil {
+		t.Fatalf("Unexpected migration files %v", files)
+	}
+	if files[3].UpFile.FileName != "2024/008_h.up.sql" {
+		t.Errorf("Expected 2024/008_h.up.sql, got %v", files[3].UpFile.FileName)
+	}
+	if err := files[2].UpFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if string(files[2].UpFile.Content) != "CREATE TABLE g (id int);" {
+		t.Errorf("Unexpected content %q", files[2].UpFile.Content)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "source-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := ioutil.WriteFile(path.Join(tmpdir, "001_a.up.sql"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	RegisterFS("source-test", testFS)
+
+	tests := []struct {
+		url      string
+		versions []uint64
+		err      bool
+	}{
+		{url: tmpdir, versions: []uint64{1}},
+		{url: "file://" + tmpdir, versions: []uint64{1}},
+		{url: "file://" + path.Join(tmpdir, "missing"), err: true},
+		{url: "embed://source-test", versions: []uint64{1, 3, 7, 8}},
+		{url: "embed://unknown", err: true},
+		{url: "unknown://source", err: true},
+	}
+	for _, test := range tests {
+		src, err := Open(test.url, "sql")
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error for %v", test.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", test.url, err)
+			continue
+		}
+		versions, err := Versions(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(versions, test.versions) {
+			t.Errorf("Expected versions %v for %v, got %v", test.versions, test.url, versions)
+		}
+		src.Close()
+	}
+}